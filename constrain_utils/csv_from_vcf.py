@@ -1,5 +1,32 @@
 #!/usr/bin/env python3
 import argparse
+import ast
+import atexit
+import bisect
+import csv
+from datetime import datetime, timezone
+import fnmatch
+import glob
+import gzip
+import hashlib
+import importlib
+import io
+import json
+import os
+import pickle
+import posixpath
+import random
+import re
+import resource
+import shutil
+import sqlite3
+import sys
+import tarfile
+import tempfile
+import time
+from urllib.parse import quote
+import urllib.request
+import zipfile
 
 from cyvcf2 import VCF
 import numpy as np
@@ -7,17 +34,505 @@ import pandas as pd
 
 VERSION = "1.0.0"
 
+DEFAULT_EXTENSIONS = ".vcf,.vcf.gz,.vcf.bgz,.bcf,.vcf.zst"
+
+SITE_FILTER_POLICIES = ("keep", "skip", "flag")
+
+DEFAULT_FLUSH_EVERY = 100_000
+
+DUPLICATE_SAMPLE_POLICIES = ("warn", "error", "keep-first", "suffix")
+
+# Errors raised by cyvcf2 and htslib for VCF files they cannot parse, see --lenient
+READ_ERRORS = (OSError, RuntimeError, ValueError)
+
+# Python syntax that --filter expressions may use, after translating &&, ||, and !
+FILTER_NODES = (
+    ast.Expression, ast.BoolOp, ast.And, ast.Or, ast.UnaryOp, ast.Not, ast.USub, ast.Compare,
+    ast.Eq, ast.NotEq, ast.Lt, ast.LtE, ast.Gt, ast.GtE, ast.Name, ast.Load, ast.Constant,
+)
+
+FILTER_COMPARISONS = {
+    ast.Eq: lambda a, b: a == b,
+    ast.NotEq: lambda a, b: a != b,
+    ast.Lt: lambda a, b: a < b,
+    ast.LtE: lambda a, b: a <= b,
+    ast.Gt: lambda a, b: a > b,
+    ast.GtE: lambda a, b: a >= b,
+}
+
+# operators of --info-filter conditions, longest first so that '>=' is not parsed as '>'
+INFO_COMPARISONS = {
+    "!=": lambda a, b: a != b,
+    ">=": lambda a, b: a >= b,
+    "<=": lambda a, b: a <= b,
+    "=": lambda a, b: a == b,
+    ">": lambda a, b: a > b,
+    "<": lambda a, b: a < b,
+}
+
+# STR callers whose VCF output can be converted, see FORMAT_PARSERS
+CALLERS = ("constrain", "gangstr", "hipstr", "expansionhunter")
+
+DEFAULT_ID_FORMAT = "{chrom}_{start}"
+
+LOCUS_ID_POLICIES = ("ignore", "column", "key")
+
+ALLELE_SUPPORT_ACTIONS = ("drop", "flag")
+
+ALLELE_ORDERS = ("ascending", "descending", "vcf")
+
+# Output formats and the file extension of their output files
+OUTPUT_FORMATS = {
+    "csv": ".csv",
+    "parquet": ".parquet",
+    "jsonl": ".jsonl",
+    "feather": ".feather",
+    "sqlite": ".sqlite",
+    "duckdb": ".duckdb",
+    "xlsx": ".xlsx",
+    "bed": ".bed",
+    "avro": ".avro",
+    "msgpack": ".msgpack",
+    "orc": ".orc",
+    "bedgraph": ".bedgraph",
+}
+
+# Avro types of the SCHEMA types. All Avro fields are nullable
+AVRO_TYPES = {
+    "string": "string",
+    "integer": "long",
+    "number": "double",
+    "boolean": "boolean",
+}
+
+# Output formats that are written as text and can be compressed with --compress
+TEXT_FORMATS = ("csv", "jsonl", "bed", "bedgraph")
+
+# Output formats that can be written to stdout with --output -
+STREAM_FORMATS = TEXT_FORMATS + ("msgpack",)
+
+# Output formats with one line per locus in BED coordinates
+BED_FORMATS = ("bed", "bedgraph")
+
+# Internal columns with the location of each locus, 0-based half-open like BED. These are
+# only written by BED_FORMATS, for other formats they are used for partitioning and dropped
+COORDINATE_COLUMNS = ("chrom", "start", "end")
+
+# Compression formats for --compress and the file extension they add
+COMPRESSIONS = {
+    "gzip": ".gz",
+    "zstd": ".zst",
+}
+
+# Valid --compress-level values per compression format
+COMPRESSION_LEVELS = {
+    "gzip": range(1, 10),
+    "zstd": range(1, 23),
+}
+
+# Maximum number of rows in an Excel worksheet, including the header row
+XLSX_MAX_ROWS = 1_048_576
+
+# Output formats that write all VCF files to a single database file (--output) with a sample column
+DATABASE_FORMATS = ("sqlite", "duckdb")
+
+# Table that records are written to with database output formats
+DATABASE_TABLE = "genotypes"
+
+# Arrow types (pyarrow factory functions) of the SCHEMA types, used for columnar output formats
+ARROW_TYPES = {
+    "string": "string",
+    "integer": "int64",
+    "number": "float64",
+    "boolean": "bool_",
+}
+
+# Columns that can be written as a loci x samples matrix with --matrix
+MATRIX_VALUES = ("genotype", "copy_number", "depth", "depth_norm")
+
+# Number of consecutive loci per file that --matrix columns are spilled to when --max-memory is exceeded
+MATRIX_BUCKET_LOCI = 10_000
+
+MEMORY_UNITS = {"": 1, "K": 1 << 10, "M": 1 << 20, "G": 1 << 30, "T": 1 << 40}
+
+# Keys that --partition-by can split the output on, in the order of the directory levels
+PARTITION_KEYS = ("chrom", "sample")
+
+# Columns expected in the --catalog TSV file. All of them are joined onto the output, except the key
+CATALOG_COLUMNS = {"str_id": str, "gene": str, "disease": str, "normal_max": "Int64", "pathogenic_min": "Int64"}
+
+# Prefix of columns holding FORMAT fields copied with --passthrough-format or --passthrough-all
+PASSTHROUGH_PREFIX = "format_"
+
+# URL schemes of VCF files that htslib reads remotely. htslib finds S3 credentials
+# in the standard AWS environment variables and ~/.aws/credentials, and GCS
+# credentials in GCS_OAUTH_TOKEN
+REMOTE_SCHEMES = ("http://", "https://", "s3://", "gs://")
+
+# Remote files that can only be read through htslib, not by urllib
+HTSLIB_ONLY_SCHEMES = ("s3://", "gs://")
+
+GZIP_MAGIC = b"\x1f\x8b"
+
+# First bytes of (decompressed) VCF and BCF files
+VCF_MAGIC = (b"##fileformat=VCF", b"BCF\x02")
+
+ZSTD_MAGIC = b"\x28\xb5\x2f\xfd"
+
+# Checksum algorithms in --checksums manifests, by the length of their hexadecimal checksums
+CHECKSUM_ALGORITHMS = {32: "md5", 40: "sha1", 64: "sha256"}
+
+CHECKSUM_ACTIONS = ("error", "skip", "warn")
+
+ARCHIVE_EXTENSIONS = (".tar", ".tar.gz", ".tgz", ".zip")
+
+VCF_HEADER_COLUMNS = ["#CHROM", "POS", "ID", "REF", "ALT", "QUAL", "FILTER", "INFO"]
+
+# Fields that can be used in --id-format, with dummy values to validate format strings
+ID_FIELDS = {"chrom": "chr1", "start": 0, "end": 1, "pos": 1, "unit": "A", "period": 1}
+
+QUOTING = {
+    "minimal": csv.QUOTE_MINIMAL,
+    "all": csv.QUOTE_ALL,
+    "nonnumeric": csv.QUOTE_NONNUMERIC,
+    "none": csv.QUOTE_NONE,
+}
+
+DELIMITERS = {
+    "comma": ",",
+    "tab": "\t",
+    "semicolon": ";",
+    "pipe": "|",
+}
+
+LINE_TERMINATORS = {
+    "LF": "\n",
+    "CRLF": "\r\n",
+}
+
+SCHEMA_VERSION = "1"
+
+# Output schema versions that --schema can print
+SCHEMA_VERSIONS = (SCHEMA_VERSION,)
+
+DATAPACKAGE = "datapackage.json"
+
+INDEX_FILE = "index.tsv"
+
+# Output columns in their default order. Columns with a non-empty 'condition'
+# are only written when the corresponding command line argument is set.
+# 'constraints' follow the Table Schema specification (https://specs.frictionlessdata.io/table-schema/)
+SCHEMA = [
+    {
+        "name": "str_id", "type": "string", "condition": "",
+        "description": "Locus identifier, by default {chromosome id}_{start position} (0-based). See --id-format",
+        "constraints": {"required": True},
+    },
+    {
+        "name": "copy_number", "type": "integer", "condition": "",
+        "description": "The number of alleles that exists for this locus in the genome",
+        "constraints": {"minimum": 0},
+    },
+    {
+        "name": "frequencies", "type": "string", "condition": "",
+        "description": "String representation of Python dict. Keys are allele length, values are observed frequencies",
+    },
+    {
+        "name": "genotype", "type": "string", "condition": "",
+        "description": "String representation of Python list. List the allele lengths of the inferred genotype",
+    },
+    {
+        "name": "depth", "type": "integer", "condition": "",
+        "description": "The number of reads that mapped to this locus",
+        "constraints": {"minimum": 0},
+    },
+    {
+        "name": "depth_norm", "type": "number", "condition": "",
+        "description": "depth divided by copy_number",
+        "constraints": {"minimum": 0},
+    },
+    {
+        "name": "site_filter", "type": "string", "condition": "--site-filter flag",
+        "description": "Site-level FILTER value of the record",
+    },
+    {
+        "name": "filter", "type": "string", "condition": "--keep-filtered",
+        "description": "FT value of the record (PASS, or the reason why ConSTRain did not genotype the locus)",
+    },
+    {
+        "name": "locus_id", "type": "string", "condition": "--locus-id column",
+        "description": "Value of the VCF ID column (e.g., a catalog locus identifier)",
+    },
+    {
+        "name": "phased", "type": "boolean", "condition": "--keep-phase",
+        "description": "Whether the genotype is phased. If so, genotype lists alleles in haplotype order",
+    },
+    {
+        "name": "low_allele_support", "type": "boolean", "condition": "--allele-support-action flag",
+        "description": "Whether an allele in genotype is supported by fewer than --min-allele-support reads",
+    },
+    {
+        "name": "gene", "type": "string", "condition": "--catalog",
+        "description": "Gene symbol of the locus from the catalog",
+    },
+    {
+        "name": "disease", "type": "string", "condition": "--catalog",
+        "description": "Disease associated with the locus from the catalog",
+    },
+    {
+        "name": "normal_max", "type": "integer", "condition": "--catalog",
+        "description": "Largest allele length (in repeat units) in the normal range, from the catalog",
+        "constraints": {"minimum": 0},
+    },
+    {
+        "name": "pathogenic_min", "type": "integer", "condition": "--catalog",
+        "description": "Smallest allele length (in repeat units) in the pathogenic range, from the catalog",
+        "constraints": {"minimum": 0},
+    },
+    {
+        "name": "classification", "type": "string", "condition": "--classify",
+        "description": "String representation of Python list. Class (normal, intermediate, expanded) of each allele in genotype",
+    },
+]
+
+# Columns of the --layout long output, with one row per allele in the genotype
+# instead of one row per locus. The sample column is also added by database output formats and --combine
+LONG_SCHEMA = [
+    {
+        "name": "sample", "type": "string", "condition": "--layout long, --combine, or a database --format",
+        "description": "Sample name from the VCF header",
+    },
+    {
+        "name": "allele_length", "type": "integer", "condition": "--layout long",
+        "description": "Length of the allele in repeat units",
+        "constraints": {"minimum": 0},
+    },
+    {
+        "name": "allele_index", "type": "integer", "condition": "--layout long",
+        "description": "0-based position of the allele in the genotype",
+        "constraints": {"minimum": 0},
+    },
+    {
+        "name": "support", "type": "integer", "condition": "--layout long",
+        "description": "Number of reads with the length of the allele",
+        "constraints": {"minimum": 0},
+    },
+]
+
+# Columns of the --layout long output in their default order
+LONG_COLUMNS = ["str_id", "sample", "allele_length", "allele_index", "support"]
+
 DESCRIPTION="\
 description:\n\
-    Create CSV file based on ConSTRain VCF output. CSV file will have six columns:\n\
+    Create CSV file based on ConSTRain VCF output. CSV file will have six columns by default:\n\
         str_id:         {chromosome id}_{start position} (0-based).\n\
         copy_number:    the number of alleles that exists for this locus in the genome.\n\
         frequencies:    string representation of Python dict. Keys are allele length, values are observed frequencies.\n\
         genotype:       string representation of Python list. List the allele lengths of the inferred genotype.\n\
         depth:          the number of reads that mapped to this locus\n\
-        depth_norm:     depth divided by copy_number.\
+        depth_norm:     depth divided by copy_number.\n\
+        site_filter:    site-level FILTER value of the record (only with --site-filter flag).\n\
+        filter:         FT value of the record (only with --keep-filtered).\n\
+        locus_id:       value of the VCF ID column (only with --locus-id column).\n\
+        format_<TAG>:   FORMAT field TAG copied verbatim (only with --passthrough-format or --passthrough-all).\n\
+        phased:         whether genotype is phased and in haplotype order (only with --keep-phase).\n\
+        low_allele_support: allele in genotype has too little read support (only with --allele-support-action flag).\n\
+        gene, disease, normal_max, pathogenic_min: locus annotations (only with --catalog).\n\
+        classification: normal/intermediate/expanded class of each allele in genotype (only with --classify).\n\
+    VCF files of other STR callers (GangSTR, HipSTR, ExpansionHunter) are converted to the same columns\n\
+    with --caller.\n\
+    Inputs can be VCF files (or http(s)://, s3://, and gs:// URLs), directories, glob patterns,\n\
+    files listing one input per line, or '-' for stdin. VCF files can be uncompressed, bgzipped, gzipped,\n\
+    or zstd-compressed (zstd-compressed files are decompressed to a temporary file first).\n\
+    When reading from stdin without --output, output is written to stdout and all messages to stderr,\n\
+    so that the script can be used in a pipeline. A VCF file on stdin is streamed and only read once,\n\
+    so only one of its samples can be converted.\n\
+    Directories and archives (.tar, .tar.gz, .tgz, .zip) are searched recursively for files matching --extensions.\n\
+    VCF files in archives are extracted to a temporary file one at a time, and their output files are\n\
+    named after their path in the archive.\n\
+    If anything other than a single VCF file is given, --output is a directory and one CSV file is written\n\
+    per VCF file.\n\
+    With --format parquet, feather, or orc, Parquet, Arrow IPC (Feather v2), or ORC files with the same columns\n\
+    are written instead of CSV files.\n\
+    With --format msgpack, a stream of MessagePack maps (one per record) is written, typed like jsonl.\n\
+    With --format avro, an Avro container file is written, with the Avro schema (including column\n\
+    descriptions) embedded in the file header.\n\
+    With --format xlsx, an Excel workbook with a frozen header row is written, meant for manual review\n\
+    of individual samples (Excel worksheets are limited to 1048575 records).\n\
+    With --format bed, a BED file with chrom, start, end, str_id, genotype (comma-separated allele lengths)\n\
+    and depth columns is written, e.g. for intersecting loci with bedtools.\n\
+    With --format bedgraph, a bedGraph track of depth_norm is written, e.g. for viewing in IGV.\n\
+    Loci without a depth_norm value are not written.\n\
+    With --combine, the records of all VCF files are written to a single file with an additional sample column.\n\
+    With --format sqlite or duckdb, --output is a database file to which the records of all VCF files are added,\n\
+    in a table named genotypes with an additional sample column. Existing records of a sample are replaced.\n\
+    With --format jsonl, every record is written as a JSON object on its own line, in which frequencies\n\
+    is an object, genotype and classification are arrays, and missing values are null.\n\
+    With --layout long, every allele in a genotype is written as a separate row with columns str_id,\n\
+    sample, allele_length, allele_index, and support (number of reads with that allele length).\n\
+    Loci without a genotype are not written.\n\
+    With --matrix, --output is always a directory, to which a matrix_<column>.csv file with one row\n\
+    per locus (str_id) and one column per sample is written in addition to the per-sample files.\n\
+    With --partition-by, --output is always a directory with a Hive-style layout, e.g.\n\
+    chrom=chr1/sample=NA12878/part-0000.csv, in which the partition keys are not repeated as columns.\
 " 
 
+def column_list(s: str) -> list:
+    # whether the columns are written with the chosen --layout and --format is checked in parse_cla
+    columns = [col.strip() for col in s.split(",") if col.strip()]
+    known = [field["name"] for field in SCHEMA + LONG_SCHEMA]
+    unknown = [col for col in columns if col not in known and not col.startswith(PASSTHROUGH_PREFIX)]
+    if unknown:
+        raise argparse.ArgumentTypeError(
+            f"unknown column(s) {unknown}, must be one of {known} or a {PASSTHROUGH_PREFIX}<TAG> column"
+        )
+    if len(set(columns)) != len(columns):
+        raise argparse.ArgumentTypeError("columns may only be listed once")
+    return columns
+
+def memory_size(s: str) -> int:
+    # number of bytes, from e.g. '4G', '512M', or '1000000'
+    m = re.fullmatch(r"(\d+(?:\.\d+)?)\s*([KMGT]?)i?B?", s.strip(), re.IGNORECASE)
+    if m is None or float(m.group(1)) <= 0:
+        raise argparse.ArgumentTypeError(f"'{s}' is not a memory size like 512M or 4G")
+    return int(float(m.group(1)) * MEMORY_UNITS[m.group(2).upper()])
+
+def positive_int(s: str) -> int:
+    val = int(s)
+    if val < 1:
+        raise argparse.ArgumentTypeError("must be at least 1")
+    return val
+
+def non_negative_int(s: str) -> int:
+    val = int(s)
+    if val < 0:
+        raise argparse.ArgumentTypeError("must be at least 0")
+    return val
+
+def positive_float(s: str) -> float:
+    val = float(s)
+    if val <= 0:
+        raise argparse.ArgumentTypeError("must be greater than 0")
+    return val
+
+def fraction(s: str) -> float:
+    val = float(s)
+    if not 0 < val <= 1:
+        raise argparse.ArgumentTypeError("must be greater than 0 and at most 1")
+    return val
+
+def id_format(s: str) -> str:
+    try:
+        s.format(**ID_FIELDS)
+    except (KeyError, IndexError, ValueError) as e:
+        raise argparse.ArgumentTypeError(f"invalid format string '{s}' ({e!r}), fields must be one of {list(ID_FIELDS)}")
+    return s
+
+def partition_keys(s: str) -> list:
+    keys = [key.strip() for key in s.split(",") if key.strip()]
+    unknown = [key for key in keys if key not in PARTITION_KEYS]
+    if unknown or not keys:
+        raise argparse.ArgumentTypeError(f"partition keys must be one or more of {list(PARTITION_KEYS)}")
+    # directory levels always follow PARTITION_KEYS order
+    return [key for key in PARTITION_KEYS if key in keys]
+
+def region_list(s: str) -> list:
+    regions = [region.strip() for region in s.split(",") if region.strip()]
+    invalid = [region for region in regions if not re.fullmatch(r"[^:\s]+(:\d+(-\d+)?)?", region)]
+    if invalid or not regions:
+        raise argparse.ArgumentTypeError(f"invalid region(s) {invalid}, expected chrom, chrom:start, or chrom:start-end")
+    for region in regions:
+        _, start, end = parse_region(region)
+        if end is not None and start > end:
+            raise argparse.ArgumentTypeError(f"invalid region {region}, start is greater than end")
+    return regions
+
+def sample_map(path: str) -> dict:
+    # two tab-separated columns: sample name in the VCF header, sample name in the output
+    names = dict()
+    try:
+        with open(path) as f:
+            for line_no, line in enumerate(f, start=1):
+                if not line.strip() or line.startswith("#"):
+                    continue
+                fields = [field.strip() for field in line.rstrip("\r\n").split("\t")]
+                if len(fields) != 2 or not all(fields):
+                    raise argparse.ArgumentTypeError(
+                        f"line {line_no} of {path} does not have two tab-separated columns"
+                    )
+                if fields[0] in names:
+                    raise argparse.ArgumentTypeError(f"sample {fields[0]} is listed more than once in {path}")
+                names[fields[0]] = fields[1]
+    except OSError as e:
+        raise argparse.ArgumentTypeError(f"cannot read {path}: {e}")
+    return names
+
+def bed_regions(path: str) -> list:
+    # BED intervals (0-based, half-open) as --regions strings, with overlapping intervals merged
+    intervals = dict()
+    try:
+        with open(path) as f:
+            for line_no, line in enumerate(f, start=1):
+                if not line.strip() or line.startswith(("#", "track", "browser")):
+                    continue
+                fields = line.split("\t")
+                try:
+                    intervals.setdefault(fields[0], []).append((int(fields[1]), int(fields[2])))
+                except (IndexError, ValueError):
+                    raise argparse.ArgumentTypeError(f"line {line_no} of {path} is not a BED record")
+    except OSError as e:
+        raise argparse.ArgumentTypeError(f"cannot read {path}: {e}")
+
+    regions = []
+    for chrom, spans in intervals.items():
+        merged = []
+        for start, end in sorted(spans):
+            if merged and start <= merged[-1][1]:
+                merged[-1][1] = max(merged[-1][1], end)
+            else:
+                merged.append([start, end])
+        regions += [f"{chrom}:{start + 1}-{end}" for start, end in merged]
+    if not regions:
+        raise argparse.ArgumentTypeError(f"{path} does not contain any BED records")
+    return regions
+
+def int_list(s: str) -> list:
+    try:
+        values = [int(value) for value in s.split(",") if value.strip()]
+    except ValueError:
+        raise argparse.ArgumentTypeError(f"'{s}' is not a comma-separated list of integers")
+    if not values:
+        raise argparse.ArgumentTypeError("at least one value must be provided")
+    return values
+
+def info_condition(s: str) -> tuple:
+    # (key, operator, values) from e.g. 'RU=CAG,CTG' or 'REF>=10'
+    op = min(INFO_COMPARISONS, key=lambda op: (s.find(op) if op in s else len(s), -len(op)))
+    key, _, value = s.partition(op)
+    values = [typed_value(v.strip()) for v in value.split(",") if v.strip()]
+    if op not in s or not key.strip() or not values:
+        raise argparse.ArgumentTypeError(f"'{s}' is not a condition like KEY=VALUE or KEY>=VALUE")
+    if op not in ("=", "!=") and len(values) > 1:
+        raise argparse.ArgumentTypeError(f"only = and != conditions can have multiple values, not '{s}'")
+    return key.strip(), op, values
+
+def filter_expression(s: str) -> ast.Expression:
+    # &&, ||, and ! are translated to Python outside of string literals, so that the expression can be
+    # parsed with ast. Only comparisons of field names and literals combined with boolean operators are allowed
+    translated = re.sub(
+        r"\"[^\"]*\"|'[^']*'|&&|\|\||!(?!=)",
+        lambda m: {"&&": " and ", "||": " or ", "!": " not "}.get(m.group(), m.group()),
+        s,
+    )
+    try:
+        tree = ast.parse(translated.strip(), mode="eval")
+    except SyntaxError as e:
+        raise argparse.ArgumentTypeError(f"invalid filter expression '{s}': {e.msg}")
+    unsupported = [type(node).__name__ for node in ast.walk(tree) if not isinstance(node, FILTER_NODES)]
+    if unsupported:
+        raise argparse.ArgumentTypeError(f"invalid filter expression '{s}': unsupported syntax {unsupported}")
+    return tree
+
 def parse_cla():
     parser = argparse.ArgumentParser(
             formatter_class=argparse.RawDescriptionHelpFormatter,
@@ -25,21 +540,968 @@ def parse_cla():
             epilog=f"Script version v{VERSION}",
         )
 
-    parser.add_argument(
-        "-v", "--vcf", type=str, required=True,
-        help="VCF file output by ConSTRain from which to create a CSV file" 
-    )
-    parser.add_argument(
-        "-o", "--output", type=str, required=True,
-        help="File path where the CSV file should be written"
-    )
+    parser.add_argument(
+        "--schema", type=str, choices=("csv", "json"),
+        help="Print the columns that are written with the other arguments (e.g., --layout, --format, --catalog, \
+            --column-order), with their types, descriptions, and constraints, in the given format and exit. \
+            No input is needed, except with --passthrough-all, for which the FORMAT fields of the first input \
+            VCF file are listed. Not available for BED formats, which have fixed columns"
+    )
+    parser.add_argument(
+        "--schema-version", type=str, choices=SCHEMA_VERSIONS, default=SCHEMA_VERSION,
+        help=f"Version of the output schema printed by --schema (default: {SCHEMA_VERSION})"
+    )
+
+    parser.add_argument(
+        "inputs", type=str, nargs="*", metavar="INPUT",
+        help="VCF file (local path or http(s)://, s3://, or gs:// URL), directory, glob pattern, or file listing one of these \
+            per line, or '-' to read a VCF file from stdin. What each input is, is detected automatically"
+    )
+    parser.add_argument(
+        "-v", "--vcf", type=str, action="append", default=[],
+        help="VCF file output by ConSTRain from which to create a CSV file, or '-' to read it from stdin. \
+            Can be given multiple times"
+    )
+    parser.add_argument(
+        "-d", "--directory", type=str, action="append", default=[],
+        help=f"Directory containing VCF files output by ConSTRain from which to create CSV files. \
+            Can be given multiple times (e.g., once per batch), in which case the CSV files of each directory \
+            are written to a separate subdirectory of --output. An {INDEX_FILE} file listing the batch \
+            each sample came from, along with per-file wall time, bytes read, records written and peak memory, \
+            is written to --output"
+    )
+    parser.add_argument(
+        "--fofn", type=str,
+        help="File listing one VCF file per line. A second, tab-separated column can give the name of the \
+            output file (e.g., a sample name), relative to --output. The output extension is added if it has none. \
+            For multi-sample VCF files, the sample is added to the name, e.g. cohort.NA12878.csv"
+    )
+    parser.add_argument(
+        "-o", "--output", type=str,
+        help="File path where the CSV file should be written. \
+            Use '-' to write to stdout (text formats and msgpack only). \
+            If more than a single VCF file is given, directory where CSV files should be written. \
+            With a database --format, always the database file. Required unless --count or --head is used, \
+            or the VCF file is read from stdin ('-'), in which case output is written to stdout by default"
+    )
+    parser.add_argument(
+        "--count", action="store_true",
+        help="Do not write CSV files, instead print the number of records and the number of records \
+            per FT value for each input VCF file to stdout, per sample for multi-sample VCF files \
+            (or per --sample)"
+    )
+    parser.add_argument(
+        "--head", type=positive_int, metavar="N",
+        help="Do not write CSV files, instead convert only the first N records and print them to stdout. \
+            If --directory is used, the first VCF file that is found is previewed"
+    )
+    parser.add_argument(
+        "--limit", type=positive_int, metavar="N",
+        help="Stop converting a VCF file after N rows were written for it, e.g. to check the output of a \
+            conversion of a huge VCF file without waiting for the full run. Unlike --head, output is written \
+            to -o/--output as usual"
+    )
+    parser.add_argument(
+        "--head-format", type=str, choices=("table", "csv"), default="table",
+        help="Print --head records as an aligned table or as CSV (default: table)"
+    )
+    parser.add_argument(
+        "--extensions", type=str, default=DEFAULT_EXTENSIONS,
+        help=f"Comma-separated list of file extensions used to recognise VCF files when --directory is used \
+            (default: {DEFAULT_EXTENSIONS})"
+    )
+    parser.add_argument(
+        "--pattern", type=str,
+        help="Glob pattern, relative to each directory, selecting the VCF files to convert instead of \
+            --extensions, e.g. '*/constrain/*.vcf.gz'. Use ** to match any number of subdirectories"
+    )
+    parser.add_argument(
+        "--exclude", type=str, action="append",
+        help="Glob pattern of VCF files to skip when searching directories, matched against the path relative \
+            to the directory and against the file name, e.g. '*/failed/*' or '*.raw.vcf.gz'. \
+            Can be given multiple times"
+    )
+    parser.add_argument(
+        "--follow-symlinks", action="store_true",
+        help="Also search symlinked subdirectories for VCF files when searching directories. \
+            Symlinked VCF files are always found"
+    )
+    parser.add_argument(
+        "--checkpoint", type=str,
+        help="JSON file in which converted VCF files and their checksums are recorded when --directory is used, \
+            so that an interrupted run can be resumed with --resume-from"
+    )
+    parser.add_argument(
+        "--resume-from", type=str,
+        help="Checkpoint file of an earlier run. VCF files recorded in it are not converted again if their checksum \
+            is unchanged and their CSV file still exists. Unless --checkpoint is set, this file is also updated"
+    )
+    parser.add_argument(
+        "--skip-existing", action="store_true",
+        help="Do not convert VCF files whose output file already exists, e.g. to only convert VCF files \
+            that were added to a directory since an earlier run"
+    )
+    parser.add_argument(
+        "--newer-only", action="store_true",
+        help="Like --skip-existing, but VCF files that were modified after their output file was written \
+            are converted again"
+    )
+    parser.add_argument(
+        "--watch", type=positive_float, metavar="SECONDS",
+        help="Keep running and check the inputs for new or modified VCF files every SECONDS seconds. \
+            A VCF file is converted once its size and modification time have not changed between two checks, \
+            so that files that are still being written are not converted yet. Stop with Ctrl-C"
+    )
+    parser.add_argument(
+        "--checksums", type=str,
+        help="Checksum manifest in the format of md5sum, sha1sum, or sha256sum output, with paths relative to \
+            the manifest. Every VCF file is verified against it before it is converted, see --checksum-action"
+    )
+    parser.add_argument(
+        "--checksum-action", type=str, choices=CHECKSUM_ACTIONS, default="error",
+        help="What to do with VCF files whose checksum does not match --checksums, or that are not listed in it: \
+            stop with an error, skip the file with a warning, or only warn (default: error). \
+            Remote files (URLs) are not verified, which is reported with a warning"
+    )
+    parser.add_argument(
+        "--merge-split", action="store_true",
+        help="Convert VCF files with the same sample(s), e.g. from running ConSTRain per chromosome \
+            (sampleA.chr1.vcf.gz, sampleA.chr2.vcf.gz, ...), to a single output file named after the sample. \
+            Files are ordered by the contig and position of their first record, following the contig order \
+            in the VCF header"
+    )
+    parser.add_argument(
+        "--duplicate-samples", type=str, choices=DUPLICATE_SAMPLE_POLICIES,
+        help="What to do when several VCF files found with --directory contain the same sample. \
+            'warn' converts all files and prints a warning, 'error' aborts before converting anything, \
+            'keep-first' only converts the first file (in path order) for each sample, 'suffix' converts all \
+            files and writes the sample of the second file as <sample>_2, of the third as <sample>_3, etc. \
+            (default: error if samples are merged into one output or outputs are named after samples, \
+            i.e. with a database --format, --partition-by, --matrix, --combine, --split-samples, or \
+            --merge-split, otherwise warn)"
+    )
+    parser.add_argument(
+        "--id-format", type=id_format, default=DEFAULT_ID_FORMAT,
+        help=f"Format of the str_id column. Available fields are {{chrom}}, {{start}} (0-based), {{end}}, \
+            {{pos}} (1-based), and the repeat {{unit}} and {{period}} from the INFO column (default: {DEFAULT_ID_FORMAT})"
+    )
+    parser.add_argument(
+        "--caller", type=str, choices=CALLERS, default="constrain",
+        help="STR caller that generated the VCF files. For gangstr, genotype is taken from REPCN, frequencies \
+            from ENCLREADS (enclosing reads per allele length), and copy_number is the number of alleles \
+            in GT. For hipstr, genotype is taken from GB and frequencies from ALLREADS, converting base pair \
+            differences from the reference to allele lengths rounded to whole repeat units, and copy_number is \
+            the number of alleles in GT. For expansionhunter, genotype is taken from REPCN, frequencies from \
+            ADSP (spanning reads per allele, for alleles with SO SPANNING), depth is the sum of ADSP, ADFL, \
+            and ADIR, and copy_number is the number of alleles in REPCN (default: constrain)"
+    )
+    parser.add_argument(
+        "--locus-id", type=str, choices=LOCUS_ID_POLICIES, default="ignore",
+        help="How to use the VCF ID column. 'ignore' does not use it, 'column' adds it as a locus_id column, \
+            'key' uses it as str_id for records where it is set (default: ignore)"
+    )
+    parser.add_argument(
+        "--sample", type=str, action="append",
+        help="Only convert this sample. Can be given multiple times. VCF files with one sample are skipped \
+            if it is not selected, multi-sample VCF files are converted once for every selected sample in them, \
+            to files named <vcf>.<sample>"
+    )
+    parser.add_argument(
+        "--rename-samples", type=sample_map, metavar="TSV",
+        help="File with two tab-separated columns, mapping sample names in VCF headers to the names used in \
+            output columns and output file names, e.g. to replace sequencing IDs by study IDs. \
+            Output files of single-sample VCF files are only renamed if the VCF file is named after its sample \
+            (e.g., NA12878.vcf.gz or NA12878.constrain.vcf.gz), otherwise they keep the name of the VCF file. \
+            Samples that are not listed keep their name. --sample selects samples by their name in the VCF header"
+    )
+    parser.add_argument(
+        "--split-samples", action="store_true",
+        help="Convert every sample (or every --sample) of multi-sample VCF files to its own file, named after \
+            the sample. --output is always a directory"
+    )
+    parser.add_argument(
+        "--regions", type=region_list,
+        help="Comma-separated list of regions (chrom, chrom:start, or chrom:start-end, 1-based and inclusive) \
+            to convert. Uses the tabix (.tbi) or CSI (.csi) index next to each bgzipped VCF file to read only \
+            these regions, in which case records are written in the contig order of the VCF header. \
+            VCF files without an index are read completely, keeping only records that overlap a region. \
+            Either way, records that overlap more than one region are written once"
+    )
+    parser.add_argument(
+        "--regions-bed", type=bed_regions, metavar="BED",
+        help="BED file with regions to convert, e.g. the loci of a target panel. Works like --regions, \
+            and can be combined with it"
+    )
+    parser.add_argument(
+        "--exclude-bed", type=bed_regions, action="append", metavar="BED",
+        help="BED file with regions to exclude, e.g. segmental duplications or low-mappability regions. \
+            Loci that overlap one of its intervals are not converted. Can be given multiple times"
+    )
+    parser.add_argument(
+        "--subsample", type=fraction, metavar="FRACTION",
+        help="Only convert a random subset of about this fraction of the loci, e.g. 0.01 to prototype analyses \
+            on genome-wide output. The same loci are selected in every VCF file"
+    )
+    parser.add_argument(
+        "--seed", type=int,
+        help="Seed for --subsample, to select the same loci on every run (default: a different seed every run)"
+    )
+    parser.add_argument(
+        "--min-depth", type=int,
+        help="Only convert loci with a DP FORMAT value of at least this number of reads. \
+            Loci without a DP value are not converted when --min-depth or --max-depth is set"
+    )
+    parser.add_argument(
+        "--max-depth", type=int,
+        help="Only convert loci with a DP FORMAT value of at most this number of reads, e.g. to exclude pileups"
+    )
+    parser.add_argument(
+        "--site-filter", type=str, choices=SITE_FILTER_POLICIES, default="keep",
+        help="How to handle records with a site-level FILTER value other than PASS. \
+            'keep' ignores the FILTER column, 'skip' drops these records, \
+            'flag' keeps them and adds a site_filter column (default: keep)"
+    )
+    parser.add_argument(
+        "--keep-filtered", action="store_true",
+        help="Add a filter column with the FT value of each record, so that the reason why ConSTRain did not \
+            genotype a locus (e.g., DPZERO or CNMISSING) is kept. These records are written with a missing genotype"
+    )
+    parser.add_argument(
+        "--cn", type=int_list, metavar="CNS",
+        help="Comma-separated copy numbers (CN FORMAT values) of the loci to convert, e.g. 2 for diploid loci only"
+    )
+    parser.add_argument(
+        "--min-cn", type=int,
+        help="Only convert loci with a CN FORMAT value of at least this copy number"
+    )
+    parser.add_argument(
+        "--max-cn", type=int,
+        help="Only convert loci with a CN FORMAT value of at most this copy number"
+    )
+    parser.add_argument(
+        "--period", type=int_list, metavar="PERIODS",
+        help="Comma-separated repeat unit lengths (PERIOD INFO values) of the loci to convert, \
+            e.g. 3 for trinucleotide repeats only"
+    )
+    parser.add_argument(
+        "--variant-only", action="store_true",
+        help="Only convert loci with a genotype in which an allele length differs from the reference"
+    )
+    parser.add_argument(
+        "--filter", type=filter_expression, metavar="EXPRESSION",
+        help="Only convert records for which EXPRESSION is true, e.g. 'DP >= 15 && CN == 2 && FT == \"PASS\"'. \
+            Names refer to FORMAT fields of the sample, INFO fields, CHROM, or POS. Comparisons (==, !=, <, <=, >, >=) \
+            can be combined with &&, ||, !, and parentheses. Comparisons with missing fields are false"
+    )
+    parser.add_argument(
+        "--info-filter", type=info_condition, action="append", metavar="CONDITION",
+        help="Only convert records with an INFO field that matches CONDITION, e.g. 'RU=CAG', 'RU!=A,T', or 'REF>=10'. \
+            = and != take a comma-separated list of values. Records without the INFO field are not converted. \
+            Can be given multiple times, in which case records have to match all conditions"
+    )
+    parser.add_argument(
+        "--skip-tags", type=parse_tags, metavar="TAGS",
+        help="Comma-separated FT values of records that are not converted, e.g. DPZERO,CNZERO,CNMISSING. \
+            By default, all records are converted"
+    )
+    parser.add_argument(
+        "--quoting", type=str, choices=QUOTING.keys(), default="minimal",
+        help="Quoting style of the CSV output. With 'none', delimiters inside fields are escaped with a backslash \
+            (default: minimal)"
+    )
+    parser.add_argument(
+        "--line-terminator", type=str, choices=LINE_TERMINATORS.keys(), default="LF",
+        help="Line terminator of the CSV output (default: LF)"
+    )
+    parser.add_argument(
+        "--bom", action="store_true",
+        help="Start CSV files with a UTF-8 byte order mark, which Excel needs to detect the encoding"
+    )
+    parser.add_argument(
+        "--no-header", action="store_true",
+        help="Do not write the header row to the CSV output"
+    )
+    parser.add_argument(
+        "--na-string", type=str, default="",
+        help="String used to represent missing values in all columns of CSV, matrix, and BED output, \
+            e.g. 'NA' or '\\N' (default: empty string, '.' for BED)"
+    )
+    parser.add_argument(
+        "--delimiter", type=str, choices=tuple(DELIMITERS),
+        help="Column separator of the output files. With tab, files are written with a .tsv extension \
+            (default: comma, or semicolon with --decimal-comma)"
+    )
+    parser.add_argument(
+        "--decimal-comma", action="store_true",
+        help="Write decimal numbers with a comma as decimal separator. \
+            Columns are then separated by semicolons instead of commas, as expected by most European spreadsheet software"
+    )
+    parser.add_argument(
+        "--float-precision", type=non_negative_int,
+        help="Number of digits after the decimal point for decimal number columns (e.g., depth_norm). \
+            Decimal numbers are always written in fixed-point notation, by default with as many digits as needed"
+    )
+    parser.add_argument(
+        "--allele-order", type=str, choices=ALLELE_ORDERS, default="ascending",
+        help="Order of the allele lengths in the genotype column. \
+            'vcf' keeps the order of the REPLEN field (default: ascending)"
+    )
+    passthrough = parser.add_mutually_exclusive_group()
+    passthrough.add_argument(
+        "--passthrough-format", type=str, metavar="TAG1,TAG2",
+        help=f"Comma-separated list of FORMAT fields to copy verbatim into {PASSTHROUGH_PREFIX}<TAG> columns"
+    )
+    passthrough.add_argument(
+        "--passthrough-all", action="store_true",
+        help=f"Copy all FORMAT fields defined in the VCF header verbatim into {PASSTHROUGH_PREFIX}<TAG> columns"
+    )
+    parser.add_argument(
+        "--keep-phase", action="store_true",
+        help="Add a phased column. For phased genotypes (GT with '|'), alleles in the genotype column \
+            are listed in haplotype order, irrespective of --allele-order"
+    )
+    parser.add_argument(
+        "--min-allele-support", type=positive_int, metavar="N",
+        help="Minimum number of reads in FREQS that need to support each allele in the genotype"
+    )
+    parser.add_argument(
+        "--allele-support-action", type=str, choices=ALLELE_SUPPORT_ACTIONS, default="drop",
+        help="What to do with genotypes that have an allele with fewer than --min-allele-support reads. \
+            'drop' sets the genotype to missing, 'flag' adds a low_allele_support column (default: drop)"
+    )
+    parser.add_argument(
+        "--catalog", type=str,
+        help=f"TSV file with annotations of known loci, joined onto the output by str_id. \
+            Must have a header with the columns {', '.join(CATALOG_COLUMNS)}. \
+            Allele lengths are given in number of repeat units"
+    )
+    parser.add_argument(
+        "--classify", action="store_true",
+        help="Classify each allele of loci in the --catalog as normal (at most normal_max), \
+            expanded (at least pathogenic_min) or intermediate, and print a summary of samples with \
+            intermediate or expanded alleles to stderr"
+    )
+    parser.add_argument(
+        "--column-order", type=column_list,
+        help="Comma-separated list of columns that should be written first, in the given order. \
+            Columns that are not listed follow in their default order. With --layout long, these are columns \
+            of the long layout (e.g., sample,str_id,allele_length)"
+    )
+    parser.add_argument(
+        "--flush-every", type=positive_int, default=DEFAULT_FLUSH_EVERY,
+        help=f"Number of records to hold in memory before they are written to the output file \
+            (default: {DEFAULT_FLUSH_EVERY})"
+    )
+    parser.add_argument(
+        "--threads", type=positive_int, default=1,
+        help="Number of threads used to decompress bgzipped VCF files (default: 1)"
+    )
+    parser.add_argument(
+        "--validate-strict", action="store_true",
+        help="Before converting, check all input VCF files against the VCF specification and report every \
+            violation with its line number. Nothing is converted if any violation is found"
+    )
+    parser.add_argument(
+        "--lenient", action="store_true",
+        help="When converting several VCF files, skip files that cannot be read or converted (e.g. because of \
+            header lines that htslib rejects) with a warning, instead of aborting all conversions"
+    )
+    parser.add_argument(
+        "--warnings", action="store_true",
+        help="Write a <output>.warnings.tsv file next to each CSV file, listing the line number \
+            and reason for every record that was dropped or had values set to missing"
+    )
+    parser.add_argument(
+        "--format", type=str, choices=tuple(OUTPUT_FORMATS), default="csv",
+        help="Format of the output files. CSV specific options (e.g., --quoting, --na-string) \
+            are ignored for other formats (default: csv)"
+    )
+    parser.add_argument(
+        "--compress", type=str, choices=tuple(COMPRESSIONS),
+        help=f"Compress output files while they are written, adding the extension of the compression format \
+            (e.g., .csv.gz). Only for text output formats ({', '.join(TEXT_FORMATS)})"
+    )
+    parser.add_argument(
+        "--compress-level", type=int, metavar="N",
+        help="Compression level, 1-9 for gzip and 1-22 for zstd (default: 9 for gzip, 3 for zstd)"
+    )
+    parser.add_argument(
+        "--layout", type=str, choices=("wide", "long"), default="wide",
+        help="'wide' writes one row per locus, 'long' writes one row per allele of the genotype \
+            of each locus (default: wide)"
+    )
+    parser.add_argument(
+        "--matrix", type=str, choices=MATRIX_VALUES,
+        help="Also write a single matrix file with this column for all loci (rows) and samples (columns) \
+            to the --output directory. The matrix is held in memory until all VCF files are converted, \
+            unless --max-memory is set"
+    )
+    parser.add_argument(
+        "--max-memory", type=memory_size, metavar="SIZE",
+        help="Approximate memory budget (e.g. 4G) for the --matrix columns of all samples. When it is exceeded, \
+            the columns are spilled to temporary files and the matrix is assembled from them one part of the \
+            loci at a time. \
+            Per-sample conversion always holds at most --flush-every records in memory"
+    )
+    parser.add_argument(
+        "--partition-by", type=partition_keys, metavar="KEYS",
+        help="Comma-separated partition keys (chrom, sample). Write CSV files to a Hive-style partitioned \
+            directory layout under --output (e.g., chrom=chr1/sample=NA12878/part-0000.csv) \
+            so that tools like Spark or DuckDB can skip partitions that are not queried"
+    )
+    parser.add_argument(
+        "--combine", action="store_true",
+        help="Write the records of all VCF files to a single file (--output) with an additional sample column, \
+            e.g. to extract --regions from every sample in a directory of indexed VCF files into one table. \
+            The file has the columns of the first VCF file, which matters if they differ between VCF files \
+            (e.g., with --passthrough-all)"
+    )
+    parser.add_argument(
+        "--datapackage", action="store_true",
+        help=f"Write a {DATAPACKAGE} file describing the generated CSV file(s) next to the output. \
+            See https://specs.frictionlessdata.io/data-package/"
+    )
+
+    args = parser.parse_args()
+    has_input = args.inputs or args.vcf or args.directory or args.fofn
+    if args.schema is not None:
+        if args.format in BED_FORMATS:
+            parser.error(f"--schema cannot be combined with --format {args.format}")
+        if args.passthrough_all and not has_input:
+            parser.error("--schema with --passthrough-all needs an input to read the FORMAT fields from")
+    elif not has_input:
+        parser.error("no input given, provide one or more INPUT, -v/--vcf, -d/--directory, or --fofn")
+    if "-" in args.inputs + args.vcf and len(args.inputs + args.vcf + args.directory) > 1:
+        parser.error("reading from stdin ('-') cannot be combined with other inputs")
+    if "-" in args.inputs + args.vcf and (
+        args.validate_strict or args.checksums or args.checkpoint or args.resume_from or args.merge_split
+    ):
+        parser.error(
+            "reading from stdin ('-') cannot be combined with --validate-strict, --checksums, --checkpoint, "
+            "--resume-from, or --merge-split, which read the VCF file more than once"
+        )
+    # reading from stdin without --output is pipe mode, e.g. `zcat sample.vcf.gz | csv_from_vcf.py - > sample.csv`
+    stdin_only = args.inputs + args.vcf == ["-"] and not (args.directory or args.fofn)
+    if args.output is None and stdin_only and args.format in STREAM_FORMATS and not (args.count or args.head):
+        args.output = "-"
+    if args.output is None and not (args.count or args.head or args.schema):
+        parser.error("the following arguments are required: -o/--output")
+    if args.min_depth is not None and args.max_depth is not None and args.min_depth > args.max_depth:
+        parser.error("--min-depth cannot be larger than --max-depth")
+    if args.min_cn is not None and args.max_cn is not None and args.min_cn > args.max_cn:
+        parser.error("--min-cn cannot be larger than --max-cn")
+    if args.seed is not None and args.subsample is None:
+        parser.error("--seed requires --subsample")
+    if args.subsample is not None and args.seed is None:
+        args.seed = random.randrange(2 ** 32)
+        print(f"Subsampling loci with --seed {args.seed}", file=sys.stderr)
+    if args.duplicate_samples is None:
+        merged = (
+            args.format in DATABASE_FORMATS or args.partition_by or args.matrix or args.combine
+            or args.split_samples or args.merge_split
+        )
+        args.duplicate_samples = "error" if merged else "warn"
+    if args.skip_tags and args.keep_filtered:
+        parser.error("--skip-tags cannot be combined with --keep-filtered")
+    if args.classify and args.catalog is None:
+        parser.error("--classify requires --catalog")
+    if args.partition_by and args.datapackage:
+        parser.error("--datapackage cannot be combined with --partition-by")
+    if args.delimiter == "comma" and args.decimal_comma:
+        parser.error("--delimiter comma cannot be combined with --decimal-comma")
+    if args.compress and args.format not in TEXT_FORMATS:
+        parser.error(f"--compress cannot be combined with --format {args.format}")
+    if args.compress_level is not None:
+        if args.compress is None:
+            parser.error("--compress-level requires --compress")
+        levels = COMPRESSION_LEVELS[args.compress]
+        if args.compress_level not in levels:
+            parser.error(f"--compress-level for {args.compress} must be between {levels[0]} and {levels[-1]}")
+    if args.layout == "long" and args.format in BED_FORMATS:
+        parser.error(f"--layout long cannot be combined with --format {args.format}")
+    if args.matrix and args.format in DATABASE_FORMATS:
+        parser.error(f"--matrix cannot be combined with --format {args.format}")
+    if args.limit is not None and (args.head or args.count or args.matrix):
+        parser.error("--limit cannot be combined with --head, --count, or --matrix")
+    if args.column_order is not None:
+        available = output_column_names(args, parse_tags(args.passthrough_format))
+        # the FORMAT fields of --passthrough-all are only known once the VCF files are read
+        unavailable = [
+            col for col in args.column_order
+            if col not in available
+            and not (args.passthrough_all and args.layout == "wide" and col.startswith(PASSTHROUGH_PREFIX))
+        ]
+        if unavailable:
+            parser.error(f"--column-order column(s) {unavailable} are not written, the output has columns {available}")
+    if args.max_memory is not None and not args.matrix:
+        parser.error("--max-memory requires --matrix")
+    if args.matrix and args.resume_from:
+        parser.error("--matrix cannot be combined with --resume-from")
+    if (args.skip_existing or args.newer_only) and (
+        args.partition_by or args.matrix or args.datapackage or args.format in DATABASE_FORMATS
+    ):
+        parser.error(
+            "--skip-existing and --newer-only cannot be combined with --partition-by, --matrix, --datapackage, "
+            "or a database --format"
+        )
+    if args.split_samples and args.output == "-" and not args.combine:
+        parser.error("--split-samples cannot be combined with --output -, unless --combine is used")
+    if args.lenient and args.validate_strict:
+        parser.error("--lenient cannot be combined with --validate-strict")
+    if args.combine and (
+        args.partition_by or args.matrix or args.datapackage or args.resume_from or args.watch is not None
+        or args.skip_existing or args.newer_only or args.format in DATABASE_FORMATS
+    ):
+        parser.error(
+            "--combine cannot be combined with --partition-by, --matrix, --datapackage, --resume-from, --watch, "
+            "--skip-existing, --newer-only, or a database --format"
+        )
+    if args.watch is not None and (args.matrix or args.count or args.head or args.output == "-" or args.merge_split):
+        parser.error("--watch cannot be combined with --matrix, --count, --head, --output -, or --merge-split")
+    if args.format in DATABASE_FORMATS and args.partition_by:
+        parser.error(f"--partition-by cannot be combined with --format {args.format}")
+    if args.output == "-" and args.format not in STREAM_FORMATS:
+        parser.error(f"--output - cannot be combined with --format {args.format}")
+    if args.output == "-" and (args.datapackage or args.partition_by or args.matrix):
+        parser.error("--output - cannot be combined with --datapackage, --partition-by, or --matrix")
+    if args.format != "csv" and args.datapackage:
+        parser.error("--datapackage can only be used with --format csv")
+
+    return args
+
+def print_schema(fmt: str, columns: list, version: str = SCHEMA_VERSION):
+    fields = [schema_field(col) for col in columns]
+    if fmt == "json":
+        json.dump({"schema_version": version, "fields": fields}, sys.stdout, indent=4)
+        print()
+    else:
+        writer = csv.DictWriter(
+            sys.stdout, fieldnames=["name", "type", "condition", "description", "constraints"], extrasaction="ignore"
+        )
+        writer.writeheader()
+        # constraints are written as JSON objects, like in the json format
+        writer.writerows(
+            {**field, "constraints": json.dumps(field["constraints"]) if "constraints" in field else ""}
+            for field in fields
+        )
+
+def parse_extensions(extensions: str) -> list:
+    exts = [ext.strip() for ext in extensions.split(",") if ext.strip()]
+    if not exts:
+        raise ValueError("at least one file extension must be provided to --extensions")
+    # longest extensions first so that e.g. '.vcf.gz' is stripped before '.gz'
+    return sorted(exts, key=len, reverse=True)
+
+def matching_extension(path: str, extensions: list) -> str:
+    for ext in extensions:
+        if path.endswith(ext):
+            return ext
+    return None
+
+def is_excluded(rel_path: str, exclude: list) -> bool:
+    # glob patterns are matched against the path relative to the searched directory and against the file name
+    return any(
+        fnmatch.fnmatchcase(rel_path, pat) or fnmatch.fnmatchcase(os.path.basename(rel_path), pat)
+        for pat in exclude or []
+    )
+
+def vcf_files_from_dir(
+    directory: str,
+    extensions: list = None,
+    pattern: str = None,
+    follow_symlinks: bool = False,
+    exclude: list = None,
+) -> list:
+    # a glob pattern relative to the directory replaces matching on extensions
+    if pattern is not None:
+        matches = glob.glob(os.path.join(glob.escape(directory), pattern), recursive=True)
+        vcf_files = [f for f in matches if os.path.isfile(f)]
+    else:
+        if extensions is None:
+            extensions = parse_extensions(DEFAULT_EXTENSIONS)
+        vcf_files = []
+        visited = set()
+        for root, dirs, files in os.walk(directory, followlinks=follow_symlinks):
+            # a symlink can point to a directory that was already walked, e.g. a parent directory
+            real = os.path.realpath(root)
+            if real in visited:
+                dirs[:] = []
+                continue
+            visited.add(real)
+            for name in files:
+                if matching_extension(name, extensions) is not None:
+                    vcf_files.append(os.path.join(root, name))
+
+    return sorted(f for f in vcf_files if not is_excluded(os.path.relpath(f, directory), exclude))
+
+def optional_module(name: str, feature: str):
+    # output formats and zstd compression need packages that a plain CSV conversion does not,
+    # so they are only imported when they are used
+    try:
+        return importlib.import_module(name)
+    except ImportError:
+        package = name.split(".")[0]
+        sys.exit(f"{feature} requires the Python module {name}, install it with `pip install {package}`")
+
+def is_remote(path: str) -> bool:
+    return path.startswith(REMOTE_SCHEMES)
+
+def is_archive(path: str) -> bool:
+    return path.endswith(ARCHIVE_EXTENSIONS)
+
+def remove_tempfile(path: str):
+    if os.path.exists(path):
+        os.remove(path)
+
+class InputFiles:
+    # Reads input VCF files, which can be local files, URLs, archive members, or stdin ('-'), and keeps the
+    # state that this needs between calls. A path is only an archive member if archive_members returned it
+    def __init__(self):
+        # <archive>/<member> paths mapped to (archive, member info) tuples
+        self.members = dict()
+        # archives are kept open so that members are read in archive order without starting from the beginning,
+        # as (mtime, archive) tuples
+        self.archives = dict()
+        # extracted copies of archive members, see release
+        self.extracted = dict()
+        # decompressed copies of zstd-compressed VCF files, which htslib cannot read
+        self.decompressed = dict()
+        # stdin can only be read once, so it is opened once and its samples are kept
+        self.stdin = None
+        self.stdin_samples = None
+        # samples that are written under a suffixed name with --duplicate-samples suffix, as
+        # (VCF file, sample) tuples mapped to the number of the duplicate (2 for the second file, ...)
+        self.sample_suffixes = dict()
+
+    def is_member(self, path: str) -> bool:
+        return path in self.members
+
+    def archive(self, path: str) -> str:
+        return self.members[path][0]
+
+    def open_archive(self, archive: str):
+        # an archive that was modified since it was opened (e.g., with --watch) is opened again
+        mtime = os.stat(archive).st_mtime_ns
+        if archive not in self.archives or self.archives[archive][0] != mtime:
+            arc = zipfile.ZipFile(archive) if archive.endswith(".zip") else tarfile.open(archive)
+            self.archives[archive] = (mtime, arc)
+        return self.archives[archive][1]
+
+    def archive_files(self, archive: str) -> list:
+        # (name, member info) tuples of the regular files in a tar or zip archive, in archive order
+        arc = self.open_archive(archive)
+        if isinstance(arc, zipfile.ZipFile):
+            return [(info.filename, info) for info in arc.infolist() if not info.is_dir()]
+        return [(info.name, info) for info in arc.getmembers() if info.isfile()]
+
+    def archive_members(self, archive: str, extensions: list, pattern: str = None, exclude: list = None) -> list:
+        # VCF files in an archive in archive order, as <archive>/<member> paths. Like in directories,
+        # --pattern replaces matching on extensions, but is matched against the whole member name
+        members = []
+        for name, info in self.archive_files(archive):
+            # output paths are based on member names, which must not point outside of --output
+            name = posixpath.normpath(name.replace("\\", "/"))
+            if posixpath.isabs(name) or name.split("/")[0] == "..":
+                print(f"WARNING: {archive} contains unsafe member name {name}, skipping member", file=sys.stderr)
+                continue
+            if is_excluded(name, exclude):
+                continue
+            if pattern is not None and not fnmatch.fnmatchcase(name, pattern):
+                continue
+            if pattern is None and matching_extension(name, extensions) is None:
+                continue
+            path = os.path.join(archive, name)
+            self.members[path] = (archive, info)
+            members.append(path)
+        return members
+
+    def open_member(self, path: str):
+        # binary file object that reads an archive member directly from the archive
+        archive, info = self.members[path]
+        arc = self.open_archive(archive)
+        return arc.open(info) if isinstance(arc, zipfile.ZipFile) else arc.extractfile(info)
+
+    def extract_member(self, path: str) -> str:
+        # htslib needs a file, so archive members are extracted to a temporary file until release.
+        # Everything else (samples, checksums, sniffing the compression) reads the member without extracting it
+        if path not in self.extracted:
+            with tempfile.NamedTemporaryFile(prefix="constrain_archive_", suffix=".vcf", delete=False) as f:
+                with self.open_member(path) as src:
+                    shutil.copyfileobj(src, f)
+            atexit.register(remove_tempfile, f.name)
+            self.extracted[path] = f.name
+        return self.extracted[path]
+
+    def release(self, path: str):
+        # removes the temporary files of an archive member once it has been converted
+        if path not in self.members:
+            return
+        for tempfiles in (self.extracted, self.decompressed):
+            if path in tempfiles:
+                remove_tempfile(tempfiles.pop(path))
+
+    def size(self, path: str) -> int:
+        if is_remote(path) or path == "-":
+            return None
+        if path in self.members:
+            info = self.members[path][1]
+            return info.file_size if isinstance(info, zipfile.ZipInfo) else info.size
+        return os.path.getsize(path)
+
+    def open(self, path: str):
+        # binary file object for local paths, archive members, and URLs
+        if path in self.members:
+            return self.open_member(path)
+        if path.startswith(HTSLIB_ONLY_SCHEMES):
+            raise RuntimeError(f"{path} can only be read as a VCF file through htslib")
+        return urllib.request.urlopen(path) if is_remote(path) else open(path, "rb")
+
+    def htslib_path(self, vcf_file: str) -> str:
+        # Path that htslib can read `vcf_file` from. zstd-compressed files are decompressed
+        # to a temporary file once, outputs are still named after `vcf_file`
+        if vcf_file in self.decompressed:
+            return self.decompressed[vcf_file]
+        if vcf_file.startswith(HTSLIB_ONLY_SCHEMES) or sniff_compression(self, vcf_file) != "zstd":
+            return self.extract_member(vcf_file) if vcf_file in self.members else vcf_file
+        with tempfile.NamedTemporaryFile(prefix="constrain_zstd_", suffix=".vcf", delete=False) as f:
+            with self.open(vcf_file) as src:
+                optional_module("zstandard", "Reading zstd-compressed VCF files").ZstdDecompressor().copy_stream(src, f)
+        atexit.register(remove_tempfile, f.name)
+        self.decompressed[vcf_file] = f.name
+        return f.name
+
+    def vcf(self, vcf_file: str, **kwargs) -> VCF:
+        if vcf_file != "-":
+            return VCF(self.htslib_path(vcf_file), **kwargs)
+        # stdin is streamed by htslib, so every call returns the same VCF object, selecting its samples
+        # and threads before the records are read
+        if self.stdin is None:
+            self.stdin = VCF("-")
+            self.stdin_samples = list(self.stdin.samples)
+        if "samples" in kwargs:
+            self.stdin.set_samples(kwargs["samples"])
+        if "threads" in kwargs:
+            self.stdin.set_threads(kwargs["threads"])
+        return self.stdin
+
+    def samples(self, vcf_file: str) -> list:
+        # archive members are only extracted for htslib if they cannot be read as text, and released right away
+        if vcf_file == "-":
+            self.vcf(vcf_file)
+            return list(self.stdin_samples)
+        if vcf_file not in self.members:
+            return list(self.vcf(vcf_file).samples)
+        header = text_header(self, vcf_file)
+        if header is not None:
+            return header[1]
+        extracted = vcf_file in self.extracted
+        samples = list(self.vcf(vcf_file).samples)
+        if not extracted:
+            self.release(vcf_file)
+        return samples
+
+def release_input(files: InputFiles, vcf_file: str, parts: dict):
+    for f in [vcf_file] + parts.get(vcf_file, []):
+        files.release(f)
+
+def sniff_compression(files: InputFiles, path: str) -> str:
+    # Returns 'bgzf', 'gzip', 'zstd', or None for uncompressed files, based on the first bytes of the file
+    with files.open(path) as f:
+        head = f.read(16)
+    if head.startswith(GZIP_MAGIC):
+        # BGZF blocks are gzip members with an extra field (FLG.FEXTRA) containing the 'BC' subfield
+        return "bgzf" if len(head) >= 14 and head[3] & 4 and head[12:14] == b"BC" else "gzip"
+    if head.startswith(ZSTD_MAGIC):
+        return "zstd"
+    return None
+
+def open_vcf_text(files: InputFiles, vcf_file: str):
+    # bgzipped files are valid gzip files
+    compression = sniff_compression(files, vcf_file)
+    if is_remote(vcf_file) or files.is_member(vcf_file):
+        f = files.open(vcf_file)
+        if compression in ("bgzf", "gzip"):
+            f = gzip.GzipFile(fileobj=f)
+        elif compression == "zstd":
+            f = optional_module("zstandard", "Reading zstd-compressed VCF files").ZstdDecompressor().stream_reader(f)
+        return io.TextIOWrapper(f)
+    if compression in ("bgzf", "gzip"):
+        return gzip.open(vcf_file, "rt")
+    if compression == "zstd":
+        return optional_module("zstandard", "Reading zstd-compressed VCF files").open(vcf_file, "rt")
+    return open(vcf_file, "r")
+
+def text_header(files: InputFiles, vcf_file: str) -> tuple:
+    # (contigs, samples, (CHROM, POS) of the first record or None) of a VCF file read as text,
+    # or None if it is not a text VCF file (e.g., BCF)
+    contigs = []
+    try:
+        with open_vcf_text(files, vcf_file) as f:
+            for line_no, line in enumerate(f, start=1):
+                if line_no == 1 and not line.startswith("##fileformat=VCF"):
+                    return None
+                if line.startswith("##contig="):
+                    m = re.search(r"[<,]ID=([^,>]+)", line)
+                    if m:
+                        contigs.append(m.group(1))
+                elif line.startswith("#CHROM"):
+                    samples = line.rstrip("\r\n").split("\t")[9:]
+                    record = next(f, "").split("\t")
+                    return contigs, samples, (record[0], int(record[1])) if len(record) > 1 else None
+    except UnicodeDecodeError:
+        return None
+    return None
+
+def is_vcf_file(files: InputFiles, path: str) -> bool:
+    # based on the content rather than the extension, so that misnamed files are recognised.
+    # Read as bytes, because BCF files are binary
+    try:
+        compression = sniff_compression(files, path)
+        with files.open(path) as f:
+            if compression in ("bgzf", "gzip"):
+                head = gzip.GzipFile(fileobj=f).read(16)
+            elif compression == "zstd":
+                zstandard = optional_module("zstandard", "Reading zstd-compressed VCF files")
+                try:
+                    head = zstandard.ZstdDecompressor().stream_reader(f).read(16)
+                except zstandard.ZstdError:
+                    return False
+            else:
+                head = f.read(16)
+    except (OSError, EOFError):
+        return False
+    return head.startswith(VCF_MAGIC)
+
+def is_text_file(files: InputFiles, path: str) -> bool:
+    if sniff_compression(files, path) is not None:
+        return False
+    with open(path, "rb") as f:
+        return b"\0" not in f.read(8192)
+
+def validate_vcf(files: InputFiles, vcf_file: str) -> list:
+    errors = []
+    ids = {"INFO": set(), "FORMAT": set(), "FILTER": {"PASS"}, "contig": set()}
+    header = None
+
+    with open_vcf_text(files, vcf_file) as f:
+        for line_no, line in enumerate(f, start=1):
+            line = line.rstrip("\r\n")
+            if line_no == 1:
+                if line.startswith("BCF"):
+                    return [(line_no, "strict validation is only supported for VCF files, not BCF")]
+                if not line.startswith("##fileformat=VCFv4"):
+                    errors.append((line_no, "first line must be '##fileformat=VCFv4.x'"))
+            if line.startswith("##"):
+                m = re.match(r"##(INFO|FORMAT|FILTER|contig)=<(.*)>$", line)
+                if m:
+                    attrs = dict(re.findall(r'(\w+)=("[^"]*"|[^,]*)', m.group(2)))
+                    if "ID" not in attrs:
+                        errors.append((line_no, f"{m.group(1)} header line without ID"))
+                        continue
+                    ids[m.group(1)].add(attrs["ID"])
+                    if m.group(1) in ("INFO", "FORMAT"):
+                        for attr in ("Number", "Type", "Description"):
+                            if attr not in attrs:
+                                errors.append((line_no, f"{m.group(1)} {attrs['ID']} is missing the {attr} attribute"))
+                continue
+            if line.startswith("#"):
+                header = line.split("\t")
+                if header[:8] != VCF_HEADER_COLUMNS or (len(header) > 8 and header[8] != "FORMAT"):
+                    errors.append((line_no, f"header line must start with {', '.join(VCF_HEADER_COLUMNS)}(, FORMAT)"))
+                continue
+            if header is None:
+                errors.append((line_no, "record before the #CHROM header line"))
+                header = VCF_HEADER_COLUMNS
+            errors.extend((line_no, msg) for msg in validate_vcf_record(line.split("\t"), header, ids))
+
+    return errors
+
+def validate_vcf_record(fields: list, header: list, ids: dict) -> list:
+    if len(fields) != len(header):
+        return [f"expected {len(header)} tab-separated columns, found {len(fields)}"]
+
+    errors = []
+    chrom, pos, _, ref, alt, qual, filt, info = fields[:8]
+    if ids["contig"] and chrom not in ids["contig"]:
+        errors.append(f"contig {chrom} is not defined in the header")
+    if not pos.isdigit() or int(pos) < 1:
+        errors.append(f"POS must be a positive integer, found '{pos}'")
+    if not re.fullmatch(r"[ACGTNacgtn]+", ref):
+        errors.append(f"invalid REF allele '{ref}'")
+    if alt != "." and not all(re.fullmatch(r"[ACGTNacgtn*]+|<[^<>]+>", a) for a in alt.split(",")):
+        errors.append(f"invalid ALT allele(s) '{alt}'")
+    if qual != ".":
+        try:
+            float(qual)
+        except ValueError:
+            errors.append(f"QUAL must be a number or '.', found '{qual}'")
+    if filt != ".":
+        errors.extend(f"FILTER {f} is not defined in the header" for f in filt.split(";") if f not in ids["FILTER"])
+    if info != ".":
+        keys = [i.split("=")[0] for i in info.split(";")]
+        errors.extend(f"INFO field {k} is not defined in the header" for k in keys if k not in ids["INFO"])
+    if len(fields) > 8:
+        keys = fields[8].split(":")
+        errors.extend(f"FORMAT field {k} is not defined in the header" for k in keys if k not in ids["FORMAT"])
+        for sample, value in zip(header[9:], fields[9:]):
+            if len(value.split(":")) > len(keys):
+                errors.append(f"sample {sample} has more values than FORMAT fields")
 
-    return parser.parse_args()
+    return errors
 
-def df_from_vcf(vcf_file: str) -> pd.DataFrame:
-    vcf = VCF(vcf_file)
-    if len(vcf.samples) != 1:
-        raise RuntimeError("this script currently only supports analysing VCF files with exactly one sample")
+def deduplicate_samples(
+    files: InputFiles, vcf_files: list, policy: str = "warn", lenient: bool = False, seen: dict = None
+) -> list:
+    # `seen` maps samples to the VCF files they were found in. With --watch, it is kept across checks,
+    # so that a sample that was converted earlier is a duplicate, unless it is a modified copy of the same file
+    seen = dict() if seen is None else seen
+    keep = []
+    for vcf_file in vcf_files:
+        try:
+            samples = files.samples(vcf_file)
+        except READ_ERRORS as e:
+            if not lenient:
+                raise
+            print(f"WARNING: cannot read {vcf_file} ({e}), skipping file", file=sys.stderr)
+            continue
+        duplicates = [s for s in samples if s in seen and vcf_file not in seen[s]]
+        for sample in duplicates:
+            msg = f"sample '{sample}' in {vcf_file} was already found in {seen[sample][0]}"
+            if policy == "error":
+                raise RuntimeError(msg)
+            if policy == "suffix":
+                seen[sample].append(vcf_file)
+                files.sample_suffixes[(vcf_file, sample)] = len(seen[sample])
+                msg += f", writing it as {sample}_{len(seen[sample])}"
+            print(f"WARNING: {msg}" + (", skipping file" if policy == "keep-first" else ""), file=sys.stderr)
+        if duplicates and policy == "keep-first":
+            continue
+        for sample in samples:
+            seen.setdefault(sample, [vcf_file])
+        keep.append(vcf_file)
+
+    return keep
+
+def csv_path_for_vcf(
+    vcf_file: str, directory: str, output_dir: str, extensions: list, suffix: str = ".csv", sample: str = None
+) -> str:
+    # loose VCF files (i.e., not found in a directory) are written directly to output_dir
+    rel_path = os.path.basename(vcf_file) if directory is None else os.path.relpath(vcf_file, directory)
+    ext = matching_extension(rel_path, extensions)
+    stem = rel_path[:-len(ext)] if ext is not None else os.path.splitext(rel_path)[0]
+    # samples selected from multi-sample VCF files each get their own file
+    if sample is not None:
+        stem += f".{sample}"
+    return os.path.join(output_dir, stem + suffix)
+
+def new_columns(
+    site_filter: str,
+    locus_id: str,
+    keep_phase: bool,
+    passthrough: list,
+    coordinates: bool = False,
+    keep_filtered: bool = False,
+) -> dict:
     df = {
         "str_id": [],
         "copy_number": [],        
@@ -47,34 +1509,391 @@ def df_from_vcf(vcf_file: str) -> pd.DataFrame:
         "genotype": [],
         "depth": [],
     }
+    if site_filter == "flag":
+        df["site_filter"] = []
+    if keep_filtered:
+        df["filter"] = []
+    if locus_id == "column":
+        df["locus_id"] = []
+    if keep_phase:
+        df["phased"] = []
+    for tag in passthrough:
+        df[PASSTHROUGH_PREFIX + tag] = []
+    # only used for partitioning and by the BED output formats, see COORDINATE_COLUMNS
+    if coordinates:
+        for col in COORDINATE_COLUMNS:
+            df[col] = []
 
-    for variant in vcf:
-        df["str_id"].append(f"{variant.CHROM}_{variant.POS - 1}")
-        df = parse_constrain_format_field(df, variant)
-    
-    df = pd.DataFrame(df).assign(depth_norm = lambda x: x["depth"] / x["copy_number"])
     return df
 
-def parse_constrain_format_field(df: dict, variant) -> dict:
+def df_from_columns(df: dict) -> pd.DataFrame:
+    # integer columns are nullable so that missing values don't turn them into floats
+    df = (
+        pd.DataFrame(df)
+        .assign(depth_norm = lambda x: x["depth"] / x["copy_number"])
+        .astype({"copy_number": "Int64", "depth": "Int64"})
+    )
+    return df
+
+def str_id(variant, fmt: str = DEFAULT_ID_FORMAT) -> str:
+    if fmt == DEFAULT_ID_FORMAT:
+        return f"{variant.CHROM}_{variant.POS - 1}"
+    return fmt.format(
+        chrom=variant.CHROM,
+        start=variant.POS - 1,
+        end=variant.INFO.get("END"),
+        pos=variant.POS,
+        unit=variant.INFO.get("RU"),
+        period=variant.INFO.get("PERIOD"),
+    )
+
+def parse_region(region: str) -> tuple:
+    # (chrom, start, end), 1-based and inclusive like tabix regions. end is None for 'chrom' and 'chrom:start'
+    chrom, _, span = region.partition(":")
+    if not span:
+        return chrom, 1, None
+    start, _, end = span.partition("-")
+    return chrom, int(start), int(end) if end else None
+
+def record_end(variant) -> int:
+    # cyvcf2 only takes END into account for symbolic alleles
+    end = variant.INFO.get("END")
+    return end if end is not None else variant.end
+
+def interval_index(regions: list) -> dict:
+    # per contig, the sorted start and end positions of the regions, with overlapping regions merged.
+    # Regions without an end ('chrom' and 'chrom:start') extend to the end of the contig
+    intervals = [parse_region(region) for region in regions]
+    index = dict()
+    for chrom, start, end in sorted((c, s, e if e is not None else float("inf")) for c, s, e in intervals):
+        starts, ends = index.setdefault(chrom, ([], []))
+        if ends and start <= ends[-1] + 1:
+            ends[-1] = max(ends[-1], end)
+        else:
+            starts.append(start)
+            ends.append(end)
+    return index
+
+def indexed_records(vcf, regions: list):
+    # (line, record) tuples of the records that overlap the regions, read through the index.
+    # Overlapping regions are merged, and a record that overlaps two regions is only yielded for the first.
+    # The index is used to seek to each region, so line numbers are unknown
+    index = interval_index(regions)
+    contigs = list(vcf.seqnames)
+    for chrom in sorted(index, key=lambda c: contigs.index(c) if c in contigs else len(contigs)):
+        starts, ends = index[chrom]
+        previous_end = 0
+        for start, end in zip(starts, ends):
+            region = f"{chrom}:{start}-{end}" if end != float("inf") else f"{chrom}:{start}"
+            for variant in vcf(region):
+                if variant.POS > previous_end:
+                    yield ".", variant
+            previous_end = end
+
+def in_index(variant, index: dict) -> bool:
+    if variant.CHROM not in index:
+        return False
+    starts, ends = index[variant.CHROM]
+    # last region that starts before the end of the record
+    i = bisect.bisect_right(starts, record_end(variant)) - 1
+    return i >= 0 and ends[i] >= variant.POS
+
+def is_sampled(variant, fraction: float, seed: int) -> bool:
+    # hashing the locus instead of drawing random numbers selects the same loci in every sample and every run
+    key = f"{seed}:{variant.CHROM}:{variant.POS}:{variant.REF}".encode()
+    digest = hashlib.blake2b(key, digest_size=8).digest()
+    return int.from_bytes(digest, "big") < fraction * 2 ** 64
+
+def format_int(variant, tag: str) -> int:
+    # first value of an integer FORMAT field of the sample, or None if it is missing
+    try:
+        value = variant.format(tag)[0][0]
+    except TypeError:
+        return None
+    # cyvcf2 represents missing integers as negative numbers
+    return int(value) if value >= 0 else None
+
+def format_str(variant, tag: str) -> str:
+    # value of a string FORMAT field of the sample, or None if it is missing
+    try:
+        value = variant.format(tag)[0]
+    except TypeError:
+        return None
+    return value if value not in ("", ".") else None
+
+def record_period(variant) -> int:
+    # ExpansionHunter VCF files only have the repeat unit (RU) in the INFO column, not its length
+    period = variant.INFO.get("PERIOD")
+    if period is None and variant.INFO.get("RU") is not None:
+        period = len(variant.INFO.get("RU"))
+    return period
+
+def is_variant(variant) -> bool:
+    # Whether the length of an allele in GT differs from the length of the reference allele.
+    # ExpansionHunter uses symbolic alleles (e.g., <STR15>), so its REPCN is compared to the INFO REF instead
+    if any(alt.startswith("<") for alt in variant.ALT):
+        ref = variant.INFO.get("REF")
+        repcn = format_str(variant, "REPCN")
+        if ref is None or repcn is None:
+            return False
+        return any(int(n) != ref for n in repcn.split("/") if n.isdigit())
+    alleles = [variant.REF] + variant.ALT
+    return any(i > 0 and len(alleles[i]) != len(variant.REF) for i in variant.genotypes[0][:-1])
+
+def in_range(value, minimum=None, maximum=None) -> bool:
+    # missing values are never in range
+    if value is None:
+        return False
+    return (minimum is None or value >= minimum) and (maximum is None or value <= maximum)
+
+def field_value(variant, name: str, values: dict):
+    # FORMAT field of the sample, INFO field, or CHROM/POS of the record, as int, float, or str.
+    # None if the field is missing
+    if name in values:
+        value = values[name]
+    elif name in ("CHROM", "POS"):
+        return getattr(variant, name)
+    else:
+        value = variant.INFO.get(name)
+    return typed_value(value)
+
+def typed_value(value):
+    # value as int or float if possible, otherwise as is. None for missing values
+    if value is None or value == ".":
+        return None
+    for typ in (int, float):
+        try:
+            return typ(value)
+        except (TypeError, ValueError):
+            pass
+    return value
+
+def info_matches(variant, condition: tuple) -> bool:
+    key, op, values = condition
+    value = typed_value(variant.INFO.get(key))
+    if value is None:
+        return False
+    if op == "=":
+        return value in values
+    if op == "!=":
+        return value not in values
+    try:
+        return INFO_COMPARISONS[op](value, values[0])
+    except TypeError:
+        return False
+
+def evaluate_filter(node, variant, values: dict):
+    if isinstance(node, ast.Expression):
+        return evaluate_filter(node.body, variant, values)
+    if isinstance(node, ast.BoolOp):
+        results = (bool(evaluate_filter(value, variant, values)) for value in node.values)
+        return all(results) if isinstance(node.op, ast.And) else any(results)
+    if isinstance(node, ast.UnaryOp):
+        operand = evaluate_filter(node.operand, variant, values)
+        if isinstance(node.op, ast.Not):
+            return not operand
+        return -operand if operand is not None else None
+    if isinstance(node, ast.Compare):
+        # comparisons with missing fields, or of numbers with strings, are false
+        left = evaluate_filter(node.left, variant, values)
+        for op, comparator in zip(node.ops, node.comparators):
+            right = evaluate_filter(comparator, variant, values)
+            try:
+                if left is None or right is None or not FILTER_COMPARISONS[type(op)](left, right):
+                    return False
+            except TypeError:
+                return False
+            left = right
+        return True
+    if isinstance(node, ast.Name):
+        return field_value(variant, node.id, values)
+    return node.value
+
+def record_filters(args) -> list:
+    # predicates that a record has to pass to be converted, based on the filtering command line arguments
+    filters = []
+    if args.skip_tags:
+        filters.append(lambda variant: format_str(variant, "FT") not in args.skip_tags)
+    if args.filter is not None:
+        filters.append(lambda variant: bool(evaluate_filter(args.filter, variant, format_values(variant))))
+    for condition in args.info_filter or []:
+        filters.append(lambda variant, condition=condition: info_matches(variant, condition))
+    if args.min_depth is not None or args.max_depth is not None:
+        filters.append(lambda variant: in_range(format_int(variant, "DP"), args.min_depth, args.max_depth))
+    if args.cn is not None:
+        filters.append(lambda variant: format_int(variant, "CN") in args.cn)
+    if args.min_cn is not None or args.max_cn is not None:
+        filters.append(lambda variant: in_range(format_int(variant, "CN"), args.min_cn, args.max_cn))
+    if args.period is not None:
+        filters.append(lambda variant: record_period(variant) in args.period)
+    if args.variant_only:
+        filters.append(is_variant)
+    if args.exclude_bed:
+        excluded = interval_index([region for regions in args.exclude_bed for region in regions])
+        filters.append(lambda variant: not in_index(variant, excluded))
+    if args.subsample is not None:
+        filters.append(lambda variant: is_sampled(variant, args.subsample, args.seed))
+    return filters
+
+def has_index(vcf_file: str, vcf: VCF, region: str) -> bool:
+    if not is_remote(vcf_file):
+        return os.path.exists(f"{vcf_file}.tbi") or os.path.exists(f"{vcf_file}.csi")
+    # for remote files, htslib looks for the index itself, which only fails once a region is queried
+    try:
+        vcf(region)
+    except READ_ERRORS:
+        return False
+    return True
+
+def dfs_from_vcf(
+    files: InputFiles,
+    vcf_file: str,
+    site_filter: str = "keep",
+    chunksize: int = None,
+    threads: int = 1,
+    id_format: str = DEFAULT_ID_FORMAT,
+    locus_id: str = "ignore",
+    keep_phase: bool = False,
+    passthrough: list = None,
+    warnings: list = None,
+    coordinates: bool = False,
+    regions: list = None,
+    sample: str = None,
+    caller: str = "constrain",
+    filters: list = None,
+    keep_filtered: bool = False,
+    min_allele_support: int = None,
+):
+    # Yield records as DataFrames of at most `chunksize` rows so that memory usage does not
+    # grow with the size of the VCF file. If `chunksize` is None, yield a single DataFrame.
+    # cyvcf2 reads through htslib, which decompresses BGZF blocks on `threads` threads.
+    # Records that are dropped or have values coerced to missing are added to `warnings`
+    # htslib only parses the FORMAT fields of `sample` when it is set, for multi-sample VCF files
+    vcf = files.vcf(vcf_file, threads=threads, **({"samples": [sample]} if sample is not None else {}))
+    if len(vcf.samples) != 1:
+        raise RuntimeError(
+            "this script currently only supports analysing VCF files with exactly one sample, use --sample"
+        )
+    if passthrough == ["*"]:
+        passthrough = format_tags(vcf)
+    elif passthrough is None:
+        passthrough = []
+    df = new_columns(site_filter, locus_id, keep_phase, passthrough, coordinates, keep_filtered)
+    n_chunks = 0
+    indexed = regions and has_index(vcf_file, vcf, regions[0])
+    if indexed:
+        records = indexed_records(vcf, regions)
+    else:
+        records = enumerate(vcf, start=vcf.raw_header.count("\n") + 1)
+    if regions and not indexed:
+        index = interval_index(regions)
+        records = ((line, variant) for line, variant in records if in_index(variant, index))
+
+    for line, variant in records:
+        # records that do not pass all filters of the command line arguments are skipped without a warning
+        if filters and not all(keep(variant) for keep in filters):
+            continue
+        # cyvcf2 reports PASS and missing ('.') site filters as None
+        if site_filter == "skip" and variant.FILTER is not None:
+            add_warning(warnings, line, variant, f"record dropped: site FILTER is {variant.FILTER}")
+            continue
+        # cyvcf2 reports a missing ('.') ID as None
+        if locus_id == "key" and variant.ID is not None:
+            df["str_id"].append(variant.ID)
+        else:
+            df["str_id"].append(str_id(variant, id_format))
+        if site_filter == "flag":
+            df["site_filter"].append(variant.FILTER if variant.FILTER is not None else "PASS")
+        if keep_filtered:
+            ft = format_str(variant, "FT")
+            df["filter"].append(ft if ft is not None else np.nan)
+        if locus_id == "column":
+            df["locus_id"].append(variant.ID if variant.ID is not None else np.nan)
+        if coordinates:
+            df["chrom"].append(variant.CHROM)
+            df["start"].append(variant.POS - 1)
+            df["end"].append(record_end(variant))
+        df = FORMAT_PARSERS[caller](df, variant, warnings, line)
+        if passthrough:
+            values = format_values(variant)
+            for tag in passthrough:
+                df[PASSTHROUGH_PREFIX + tag].append(values.get(tag, np.nan))
+        if keep_phase:
+            phased = phased_genotype(variant, caller)
+            df["phased"].append(phased is not None)
+            if phased is not None:
+                df["genotype"][-1] = phased
+        genotype, frequencies = df["genotype"][-1], df["frequencies"][-1]
+        if (
+            min_allele_support is not None and isinstance(genotype, list) and isinstance(frequencies, dict)
+            and low_allele_support(genotype, frequencies, min_allele_support)
+        ):
+            df["genotype"][-1] = np.nan
+            add_warning(warnings, line, variant, f"genotype set to missing: allele support below {min_allele_support}")
+
+        if chunksize is not None and len(df["str_id"]) >= chunksize:
+            yield df_from_columns(df)
+            n_chunks += 1
+            df = new_columns(site_filter, locus_id, keep_phase, passthrough, coordinates, keep_filtered)
+
+    if df["str_id"] or n_chunks == 0:
+        yield df_from_columns(df)
+
+def format_tags(vcf: VCF) -> list:
+    # FORMAT fields defined in the VCF header, see --passthrough-all
+    return [h.info()["ID"] for h in vcf.header_iter() if h.type == "FORMAT"]
+
+def df_from_vcf(files: InputFiles, vcf_file: str, **kwargs) -> pd.DataFrame:
+    return next(dfs_from_vcf(files, vcf_file, chunksize=None, **kwargs))
+
+def format_values(variant) -> dict:
+    # FORMAT keys and values of the (single) sample as written in the VCF record
+    fields = str(variant).rstrip("\n").split("\t")
+    if len(fields) < 10:
+        return dict()
+    return dict(zip(fields[8].split(":"), fields[9].split(":")))
+
+def phased_genotype(variant, caller: str = "constrain") -> list:
+    # Allele lengths in haplotype order, or None if the genotype is not phased.
+    # REPLEN lists allele lengths sorted, so they are derived from the GT allele indices instead
+    gt = variant.genotypes[0]
+    if not gt[-1] or any(allele < 0 for allele in gt[:-1]):
+        return None
+    # HipSTR alleles include flanking sequence and ExpansionHunter alleles are symbolic,
+    # so their lengths come from GB and REPCN, which are in haplotype order as well
+    try:
+        if caller == "hipstr":
+            return [hipstr_units(variant, int(i)) for i in variant.format("GB")[0].split("|")]
+        if caller == "expansionhunter":
+            return [int(i) for i in eh_values(variant, "REPCN")]
+    except (TypeError, ValueError):
+        return None
+    period = variant.INFO.get("PERIOD")
+    alleles = [variant.REF] + variant.ALT
+    return [len(alleles[allele]) // period for allele in gt[:-1]]
+
+def add_warning(warnings: list, line: int, variant, reason: str):
+    if warnings is not None:
+        warnings.append((line, variant.CHROM, variant.POS, reason))
+
+def parse_constrain_format_field(df: dict, variant, warnings: list = None, line: int = None) -> dict:
     try:
         df["copy_number"].append(variant.format("CN")[0][0])
     except TypeError:
         df["copy_number"].append(np.nan)
+        add_warning(warnings, line, variant, "copy_number set to missing: CN field is missing")
 
     try:
         df["depth"].append(variant.format("DP")[0][0])
     except TypeError:
         df["depth"].append(np.nan)
+        add_warning(warnings, line, variant, "depth set to missing: DP field is missing")
     
     try:
-        frequencies = variant.format("FREQS")[0]
-        freq_dict = dict()
-        for i in frequencies.split("|"):
-            i = i.split(",")
-            freq_dict[int(i[0])] = int(i[1])
-        df["frequencies"].append(freq_dict)            
-    except (TypeError, IndexError):
+        df["frequencies"].append(parse_frequencies(variant.format("FREQS")[0]))
+    except (TypeError, IndexError, ValueError):
         df["frequencies"].append(np.nan)
+        add_warning(warnings, line, variant, "frequencies set to missing: FREQS field is missing or malformed")
 
     try:
         genotypes = variant.format("REPLEN")[0]
@@ -84,15 +1903,1475 @@ def parse_constrain_format_field(df: dict, variant) -> dict:
         df["genotype"].append(genotypes)
     except (TypeError, ValueError):
         df["genotype"].append(np.nan)
+        add_warning(warnings, line, variant, "genotype set to missing: REPLEN field is missing or malformed")
+    else:
+        cn = df["copy_number"][-1]
+        if not np.isnan(cn) and len(genotypes) != cn:
+            add_warning(
+                warnings, line, variant, f"inconsistent ploidy: genotype has {len(genotypes)} alleles but CN is {cn}"
+            )
     
     return df
 
+def parse_frequencies(frequencies: str) -> dict:
+    # '<allele length>,<number of reads>|...' as in ConSTRain FREQS and GangSTR ENCLREADS
+    freq_dict = dict()
+    for i in frequencies.split("|"):
+        i = i.split(",")
+        freq_dict[int(i[0])] = int(i[1])
+    return freq_dict
+
+def parse_gangstr_format_field(df: dict, variant, warnings: list = None, line: int = None) -> dict:
+    # GangSTR does not estimate copy number, so the ploidy of the GT field is used instead
+    ploidy = len(variant.genotypes[0]) - 1
+    df["copy_number"].append(ploidy if ploidy > 0 else np.nan)
+
+    try:
+        df["depth"].append(variant.format("DP")[0][0])
+    except TypeError:
+        df["depth"].append(np.nan)
+        add_warning(warnings, line, variant, "depth set to missing: DP field is missing")
+
+    # ENCLREADS is 'NULL' for loci without enclosing reads
+    try:
+        df["frequencies"].append(parse_frequencies(variant.format("ENCLREADS")[0]))
+    except (TypeError, IndexError, ValueError):
+        df["frequencies"].append(np.nan)
+        add_warning(warnings, line, variant, "frequencies set to missing: ENCLREADS field is missing or malformed")
+
+    try:
+        # missing values of integer fields are negative in cyvcf2
+        genotypes = sorted(int(i) for i in variant.format("REPCN")[0] if i >= 0)
+        if not genotypes:
+            raise ValueError
+        df["genotype"].append(genotypes)
+    except (TypeError, ValueError):
+        df["genotype"].append(np.nan)
+        add_warning(warnings, line, variant, "genotype set to missing: REPCN field is missing")
+
+    return df
+
+def hipstr_units(variant, bp_diff: int) -> int:
+    # HipSTR reports alleles as base pair differences from the reference repeat, which spans START-END
+    start, end = variant.INFO.get("START"), variant.INFO.get("END")
+    ref_bp = end - start + 1 if start is not None and end is not None else len(variant.REF)
+    return round((ref_bp + bp_diff) / variant.INFO.get("PERIOD"))
+
+def parse_hipstr_format_field(df: dict, variant, warnings: list = None, line: int = None) -> dict:
+    # HipSTR does not estimate copy number, so the ploidy of the GT field is used instead
+    ploidy = len(variant.genotypes[0]) - 1
+    df["copy_number"].append(ploidy if ploidy > 0 else np.nan)
+
+    try:
+        df["depth"].append(variant.format("DP")[0][0])
+    except TypeError:
+        df["depth"].append(np.nan)
+        add_warning(warnings, line, variant, "depth set to missing: DP field is missing")
+
+    # ALLREADS is '<bp difference>|<number of reads>;...'
+    try:
+        freq_dict = dict()
+        for i in variant.format("ALLREADS")[0].split(";"):
+            diff, n = i.split("|")
+            units = hipstr_units(variant, int(diff))
+            freq_dict[units] = freq_dict.get(units, 0) + int(n)
+        df["frequencies"].append(dict(sorted(freq_dict.items())))
+    except (TypeError, IndexError, ValueError):
+        df["frequencies"].append(np.nan)
+        add_warning(warnings, line, variant, "frequencies set to missing: ALLREADS field is missing or malformed")
+
+    # GB is '<bp difference>|<bp difference>', or separated by '/' for unphased genotypes
+    try:
+        genotypes = sorted(hipstr_units(variant, int(i)) for i in re.split(r"[|/]", variant.format("GB")[0]))
+        df["genotype"].append(genotypes)
+    except (TypeError, ValueError):
+        df["genotype"].append(np.nan)
+        add_warning(warnings, line, variant, "genotype set to missing: GB field is missing or malformed")
+
+    return df
+
+def eh_values(variant, tag: str) -> list:
+    # ExpansionHunter writes one value per allele, separated by '/', e.g. REPCN=12/15
+    return variant.format(tag)[0].split("/")
+
+def parse_expansionhunter_format_field(df: dict, variant, warnings: list = None, line: int = None) -> dict:
+    try:
+        repcn = [int(i) for i in eh_values(variant, "REPCN")]
+    except (TypeError, ValueError):
+        repcn = None
+    df["copy_number"].append(len(repcn) if repcn is not None else np.nan)
+    if repcn is None:
+        add_warning(warnings, line, variant, "copy_number set to missing: REPCN field is missing or malformed")
+
+    # the read counts of homozygous alleles are repeated for every allele, so they are counted once
+    try:
+        depth = dict()
+        for tag in ("ADSP", "ADFL", "ADIR"):
+            for length, n in zip(repcn, eh_values(variant, tag)):
+                depth[(tag, length)] = int(n)
+        df["depth"].append(sum(depth.values()))
+    except (TypeError, ValueError):
+        df["depth"].append(np.nan)
+        add_warning(warnings, line, variant, "depth set to missing: ADSP, ADFL, or ADIR field is missing or malformed")
+
+    # only spanning reads measure the exact allele length
+    try:
+        freq_dict = dict()
+        for length, so, n in zip(repcn, eh_values(variant, "SO"), eh_values(variant, "ADSP")):
+            if so == "SPANNING" and int(n) > 0:
+                freq_dict[length] = int(n)
+        df["frequencies"].append(dict(sorted(freq_dict.items())))
+    except (TypeError, ValueError):
+        df["frequencies"].append(np.nan)
+        add_warning(warnings, line, variant, "frequencies set to missing: SO or ADSP field is missing or malformed")
+
+    if repcn is not None:
+        df["genotype"].append(sorted(repcn))
+    else:
+        df["genotype"].append(np.nan)
+        add_warning(warnings, line, variant, "genotype set to missing: REPCN field is missing or malformed")
+
+    return df
+
+# Functions that add the copy_number, depth, frequencies, and genotype of a record to the columns, per caller
+FORMAT_PARSERS = {
+    "constrain": parse_constrain_format_field,
+    "gangstr": parse_gangstr_format_field,
+    "hipstr": parse_hipstr_format_field,
+    "expansionhunter": parse_expansionhunter_format_field,
+}
+
+def read_catalog(catalog_file: str) -> pd.DataFrame:
+    catalog = pd.read_csv(catalog_file, sep="\t", dtype=CATALOG_COLUMNS)
+    missing = [col for col in CATALOG_COLUMNS if col not in catalog.columns]
+    if missing:
+        raise ValueError(f"catalog {catalog_file} is missing column(s) {missing}")
+    duplicated = catalog["str_id"][catalog["str_id"].duplicated()].tolist()
+    if duplicated:
+        raise ValueError(f"catalog {catalog_file} contains duplicate str_id(s) {duplicated}")
+
+    return catalog[list(CATALOG_COLUMNS)]
+
+def annotate(df: pd.DataFrame, catalog: pd.DataFrame) -> pd.DataFrame:
+    # left join keeps the order of the records in the VCF
+    return df.merge(catalog, on="str_id", how="left")
+
+def classify_allele(length: int, normal_max: int, pathogenic_min: int) -> str:
+    if length <= normal_max:
+        return "normal"
+    if length >= pathogenic_min:
+        return "expanded"
+    return "intermediate"
+
+def classify(df: pd.DataFrame) -> pd.DataFrame:
+    mask = df["normal_max"].notna() & df["pathogenic_min"].notna() & df["genotype"].notna()
+    rows = df.loc[mask, ["genotype", "normal_max", "pathogenic_min"]]
+    classes = [
+        [classify_allele(allele, normal_max, pathogenic_min) for allele in genotype]
+        for genotype, normal_max, pathogenic_min in rows.itertuples(index=False)
+    ]
+    # rows that are not in `classes` are set to NaN when aligning on the index
+    return df.assign(classification=pd.Series(classes, index=rows.index, dtype=object))
+
+def low_allele_support(genotype: list, frequencies: dict, min_support: int) -> bool:
+    return any(frequencies.get(allele, 0) < min_support for allele in genotype)
+
+def flag_allele_support(df: pd.DataFrame, min_support: int) -> pd.DataFrame:
+    # with --allele-support-action drop, genotypes are set to missing in dfs_from_vcf instead,
+    # where the records can be added to the warnings
+    rows = df.loc[df["genotype"].notna() & df["frequencies"].notna(), ["genotype", "frequencies"]]
+    low_support = pd.Series(
+        [low_allele_support(gt, freqs, min_support) for gt, freqs in rows.itertuples(index=False)],
+        index=rows.index,
+        dtype=object,
+    )
+    return df.assign(low_allele_support=low_support)
+
+def order_alleles(df: pd.DataFrame, order: str) -> pd.DataFrame:
+    reverse = order == "descending"
+    genotype = df["genotype"].map(lambda gt: sorted(gt, reverse=reverse) if isinstance(gt, list) else gt)
+    if "phased" in df.columns:
+        # phased genotypes stay in haplotype order
+        genotype = genotype.where(~df["phased"].astype(bool), df["genotype"])
+    return df.assign(genotype=genotype)
+
+def finalize_df(df: pd.DataFrame, args, catalog: pd.DataFrame = None) -> pd.DataFrame:
+    if args.allele_order != "vcf":
+        df = order_alleles(df, args.allele_order)
+    if args.min_allele_support is not None and args.allele_support_action == "flag":
+        df = flag_allele_support(df, args.min_allele_support)
+    if catalog is not None:
+        df = annotate(df, catalog)
+    if args.classify:
+        df = classify(df)
+
+    return df
+
+def ordered_columns(columns: list, column_order: list) -> list:
+    missing = [col for col in column_order if col not in columns]
+    if missing:
+        raise ValueError(f"column(s) {missing} passed to --column-order are not part of the output")
+    return column_order + [col for col in columns if col not in column_order]
+
+def order_columns(df: pd.DataFrame, column_order: list) -> pd.DataFrame:
+    return df[ordered_columns(list(df.columns), column_order)]
+
+def float_formatter(precision: int, decimal: str, na_rep: str):
+    # pandas falls back to scientific notation for extreme values, which breaks strict parsers
+    def formatter(value) -> str:
+        if np.isnan(value):
+            return na_rep
+        if precision is None:
+            s = np.format_float_positional(value, trim="0")
+        else:
+            s = f"{value:.{precision}f}"
+        return s.replace(".", decimal, 1)
+
+    return formatter
+
+def parse_tags(tags: str) -> list:
+    if tags is None:
+        return []
+    return [tag.strip() for tag in tags.split(",") if tag.strip()]
+
+def vcf_options(args) -> dict:
+    return {
+        "site_filter": args.site_filter,
+        "threads": args.threads,
+        "id_format": args.id_format,
+        "locus_id": args.locus_id,
+        "keep_phase": args.keep_phase,
+        # '*' selects all FORMAT fields in the header of each VCF file
+        "passthrough": ["*"] if args.passthrough_all else parse_tags(args.passthrough_format),
+        "regions": (args.regions or []) + (args.regions_bed or []),
+        "caller": args.caller,
+        "filters": record_filters(args),
+        "keep_filtered": args.keep_filtered,
+        # with --allele-support-action flag, low support is flagged by finalize_df instead
+        "min_allele_support": args.min_allele_support if args.allele_support_action == "drop" else None,
+        "coordinates": (args.partition_by is not None and "chrom" in args.partition_by) or args.format in BED_FORMATS,
+    }
+
+def output_column_names(args, passthrough: list) -> list:
+    # Columns that convert writes with these arguments, in their default order (before --column-order).
+    # Follows dfs_from_vcf, finalize_df, and the layout in convert
+    if args.layout == "long":
+        return list(LONG_COLUMNS)
+    columns = list(new_columns(args.site_filter, args.locus_id, args.keep_phase, passthrough, False, args.keep_filtered))
+    columns.append("depth_norm")
+    if args.min_allele_support is not None and args.allele_support_action == "flag":
+        columns.append("low_allele_support")
+    if args.catalog is not None:
+        columns += [col for col in CATALOG_COLUMNS if col != "str_id"]
+    if args.classify:
+        columns.append("classification")
+    if args.format in DATABASE_FORMATS or args.combine:
+        columns.insert(0, "sample")
+    return columns
+
+def compression_options(args) -> dict:
+    if args.compress is None:
+        return None
+    return {"method": args.compress, "level": args.compress_level}
+
+def csv_options(args) -> dict:
+    options = {
+        "index": False,
+        "sep": DELIMITERS[args.delimiter] if args.delimiter else (";" if args.decimal_comma else ","),
+        "decimal": "," if args.decimal_comma else ".",
+        "header": not args.no_header,
+        "quoting": QUOTING[args.quoting],
+        "lineterminator": LINE_TERMINATORS[args.line_terminator],
+        "na_rep": args.na_string,
+        "encoding": "utf-8-sig" if args.bom else "utf-8",
+    }
+    options["float_format"] = float_formatter(args.float_precision, options["decimal"], options["na_rep"])
+    if args.quoting == "none":
+        options["escapechar"] = "\\"
+
+    return options
+
+def output_suffix(fmt: str, options: dict, compression: dict = None) -> str:
+    suffix = ".tsv" if fmt == "csv" and options["sep"] == "\t" else OUTPUT_FORMATS[fmt]
+    if compression is not None:
+        suffix += COMPRESSIONS[compression["method"]]
+    return suffix
+
+def open_text(path: str, compression: dict = None, encoding: str = "utf-8"):
+    # compression is None or a dict with the compression 'method' and optionally its 'level'.
+    # '-' is stdout, which is not closed when the returned file is closed
+    if path == "-":
+        sys.stdout.flush()
+        if compression is None:
+            return open(sys.stdout.fileno(), "w", encoding=encoding, newline="", closefd=False)
+        # unbuffered, because compressed files don't flush the file object they write to
+        path = open(sys.stdout.fileno(), "wb", buffering=0, closefd=False)
+    if compression is None:
+        return open(path, "w", encoding=encoding, newline="")
+    level = compression.get("level")
+    if compression["method"] == "gzip":
+        return gzip.open(path, "wt", encoding=encoding, newline="", compresslevel=level if level is not None else 9)
+    zstandard = optional_module("zstandard", "--compress zstd")
+    cctx = zstandard.ZstdCompressor(level=level if level is not None else 3)
+    return zstandard.open(path, "wt", cctx=cctx, encoding=encoding, newline="")
+
+def schema_field(col: str) -> dict:
+    field = next((f for f in SCHEMA + LONG_SCHEMA if f["name"] == col), None)
+    if field is None:
+        field = {
+            "name": col, "type": "string", "condition": "--passthrough-format or --passthrough-all",
+            "description": f"FORMAT field {col[len(PASSTHROUGH_PREFIX):]}",
+        }
+    return field
+
+def datapackage_resource(
+    vcf_file: str, csv_file: str, package_dir: str, columns: list, options: dict
+) -> dict:
+    name = os.path.relpath(csv_file, package_dir)
+    compressed = any(name.endswith(suffix) for suffix in COMPRESSIONS.values())
+    if compressed:
+        name = os.path.splitext(name)[0]
+    name = re.sub(r"[^a-z0-9._-]", "_", os.path.splitext(name)[0].lower())
+    dialect = {
+        "delimiter": options["sep"],
+        "lineTerminator": options["lineterminator"],
+        "header": options["header"],
+        "quoteChar": "\"",
+        "doubleQuote": options["quoting"] != csv.QUOTE_NONE,
+    }
+    if "escapechar" in options:
+        dialect["escapeChar"] = options["escapechar"]
+    fields = []
+    for col in columns:
+        field = {k: v for k, v in schema_field(col).items() if k != "condition"}
+        if field["type"] == "number" and options["decimal"] != ".":
+            field["decimalChar"] = options["decimal"]
+        fields.append(field)
+
+    resource = {
+        "name": name,
+        "path": os.path.relpath(csv_file, package_dir),
+        "profile": "tabular-data-resource",
+        "format": "csv",
+        "mediatype": "text/csv",
+        # also with --bom, "utf-8-sig" is not an encoding name that other tools know
+        "encoding": "utf-8",
+        "dialect": dialect,
+        "schema": {"fields": fields, "missingValues": [options["na_rep"]]},
+        "sources": [{"title": "ConSTRain VCF", "path": os.path.abspath(vcf_file)}],
+    }
+    if compressed:
+        resource["compression"] = os.path.splitext(csv_file)[1][1:]
+
+    return resource
+
+def write_datapackage(package_dir: str, resources: list):
+    package = {
+        "profile": "tabular-data-package",
+        "name": "constrain-str-genotypes",
+        "description": f"ConSTRain STR genotypes converted by csv_from_vcf.py v{VERSION} (output schema v{SCHEMA_VERSION})",
+        "created": datetime.now(timezone.utc).isoformat(timespec="seconds"),
+        "resources": resources,
+    }
+    with open(os.path.join(package_dir, DATAPACKAGE), "w") as f:
+        json.dump(package, f, indent=4)
+
+def write_warnings(f, warnings: list):
+    f.writelines("\t".join(map(str, w)) + "\n" for w in warnings)
+    f.flush()
+    warnings.clear()
+
+def stringify_objects(df: pd.DataFrame) -> pd.DataFrame:
+    # dicts and lists are written as their string representation, like in CSV output
+    return df.assign(**{
+        col: df[col].map(lambda x: str(x) if isinstance(x, (dict, list)) else x)
+        for col in df.columns if df[col].dtype == object
+    })
+
+def arrow_table(df: pd.DataFrame):
+    # Types come from SCHEMA rather than being inferred, so that every chunk has the same schema
+    # even if a column is empty in some of them. pyarrow was imported by the writer
+    pa = importlib.import_module("pyarrow")
+    fields = []
+    for col in df.columns:
+        fields.append(pa.field(col, getattr(pa, ARROW_TYPES[schema_field(col)["type"]])()))
+    return pa.Table.from_pandas(stringify_objects(df), schema=pa.schema(fields), preserve_index=False)
+
+class CsvWriter:
+    def __init__(self, path: str, options: dict, compression: dict = None):
+        # to_csv ignores the encoding when writing to an open file, utf-8-sig writes the --bom
+        self.f = open_text(path, compression, options["encoding"])
+        self.options = options
+        self.n_chunks = 0
+
+    def write(self, df: pd.DataFrame):
+        # only the first chunk gets a header row
+        df.to_csv(self.f, **{**self.options, "header": self.options["header"] and self.n_chunks == 0})
+        self.f.flush()
+        self.n_chunks += 1
+
+    def close(self):
+        self.f.close()
+
+class ParquetWriter:
+    def __init__(self, path: str, options: dict, compression: dict = None):
+        self.pq = optional_module("pyarrow.parquet", "--format parquet")
+        self.path = path
+        self.writer = None
+
+    def write(self, df: pd.DataFrame):
+        # every chunk is written as a separate row group
+        table = arrow_table(df)
+        if self.writer is None:
+            self.writer = self.pq.ParquetWriter(self.path, table.schema)
+        self.writer.write_table(table)
+
+    def close(self):
+        if self.writer is not None:
+            self.writer.close()
+
+class FeatherWriter:
+    def __init__(self, path: str, options: dict, compression: dict = None):
+        self.ipc = optional_module("pyarrow.ipc", "--format feather")
+        self.path = path
+        self.writer = None
+
+    def write(self, df: pd.DataFrame):
+        # Feather v2 is the Arrow IPC file format, every chunk is written as a separate record batch
+        table = arrow_table(df)
+        if self.writer is None:
+            self.writer = self.ipc.new_file(self.path, table.schema)
+        self.writer.write_table(table)
+
+    def close(self):
+        if self.writer is not None:
+            self.writer.close()
+
+class OrcWriter:
+    def __init__(self, path: str, options: dict, compression: dict = None):
+        # pyarrow.orc is missing from some pyarrow builds
+        self.writer = optional_module("pyarrow.orc", "--format orc").ORCWriter(path)
+
+    def write(self, df: pd.DataFrame):
+        self.writer.write(arrow_table(df))
+
+    def close(self):
+        self.writer.close()
+
+class JsonlWriter:
+    def __init__(self, path: str, options: dict, compression: dict = None):
+        self.f = open_text(path, compression)
+
+    def write(self, df: pd.DataFrame):
+        if df.empty:
+            return
+        # older pandas versions do not end the last line with a newline
+        lines = df.to_json(orient="records", lines=True)
+        self.f.write(lines if lines.endswith("\n") else lines + "\n")
+        self.f.flush()
+
+    def close(self):
+        self.f.close()
+
+class SqliteWriter:
+    def __init__(self, path: str, options: dict, compression: dict = None):
+        self.conn = sqlite3.connect(path)
+        self.replaced = False
+
+    def write(self, df: pd.DataFrame):
+        if df.empty:
+            return
+        exists = self.conn.execute(
+            "SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = ?", (DATABASE_TABLE,)
+        ).fetchone()
+        # records of an earlier (possibly interrupted) conversion of the same sample are replaced
+        if exists and not self.replaced:
+            self.conn.execute(f"DELETE FROM {DATABASE_TABLE} WHERE sample = ?", (df["sample"].iloc[0],))
+        self.replaced = True
+        stringify_objects(df).to_sql(DATABASE_TABLE, self.conn, if_exists="append", index=False)
+        self.conn.commit()
+
+    def close(self):
+        self.conn.execute(f"CREATE INDEX IF NOT EXISTS {DATABASE_TABLE}_str_id ON {DATABASE_TABLE} (str_id)")
+        self.conn.execute(f"CREATE INDEX IF NOT EXISTS {DATABASE_TABLE}_sample ON {DATABASE_TABLE} (sample)")
+        self.conn.commit()
+        self.conn.close()
+
+class DuckdbWriter:
+    def __init__(self, path: str, options: dict, compression: dict = None):
+        optional_module("pyarrow", "--format duckdb")
+        self.conn = optional_module("duckdb", "--format duckdb").connect(path)
+        self.replaced = False
+
+    def write(self, df: pd.DataFrame):
+        if df.empty:
+            return
+        # typed through Arrow so that a chunk with an all-missing column doesn't change the table definition
+        chunk = arrow_table(df)
+        self.conn.register("chunk", chunk)
+        self.conn.execute(f"CREATE TABLE IF NOT EXISTS {DATABASE_TABLE} AS SELECT * FROM chunk LIMIT 0")
+        # records of an earlier (possibly interrupted) conversion of the same sample are replaced.
+        # Records are inserted one sample at a time, so each sample is stored in contiguous row groups
+        if not self.replaced:
+            self.conn.execute(f"DELETE FROM {DATABASE_TABLE} WHERE sample = ?", [df["sample"].iloc[0]])
+        self.replaced = True
+        self.conn.execute(f"INSERT INTO {DATABASE_TABLE} BY NAME SELECT * FROM chunk")
+        self.conn.unregister("chunk")
+
+    def close(self):
+        self.conn.close()
+
+class XlsxWriter:
+    def __init__(self, path: str, options: dict, compression: dict = None):
+        self.writer = pd.ExcelWriter(path, engine="openpyxl")
+        self.n_rows = 0
+
+    def write(self, df: pd.DataFrame):
+        if self.n_rows + len(df) >= XLSX_MAX_ROWS:
+            raise ValueError(f"--format xlsx supports at most {XLSX_MAX_ROWS - 1} records per VCF file")
+        # numeric columns are written as numbers, dicts and lists as text.
+        # All chunks are written to the same sheet, below the header row and the earlier chunks
+        first = self.n_rows == 0
+        stringify_objects(df).to_excel(
+            self.writer,
+            sheet_name=DATABASE_TABLE,
+            index=False,
+            header=first,
+            startrow=0 if first else self.n_rows + 1,
+            freeze_panes=(1, 0) if first else None,
+        )
+        self.n_rows += len(df)
+
+    def close(self):
+        self.writer.close()
+
+class MsgpackWriter:
+    def __init__(self, path: str, options: dict, compression: dict = None):
+        # '-' is stdout, which is not closed when the writer is closed
+        self.f = open(sys.stdout.fileno(), "wb", closefd=False) if path == "-" else open(path, "wb")
+        msgpack = optional_module("msgpack", "--format msgpack")
+        self.packer = msgpack.Packer(default=lambda x: x.item() if isinstance(x, np.generic) else str(x))
+
+    def write(self, df: pd.DataFrame):
+        # frequencies keys are strings like in jsonl, most msgpack readers only accept string keys
+        df = df.astype(object).where(df.notna(), None)
+        if "frequencies" in df.columns:
+            df["frequencies"] = df["frequencies"].map(
+                lambda freqs: {str(k): v for k, v in freqs.items()} if isinstance(freqs, dict) else freqs
+            )
+        for record in df.to_dict("records"):
+            self.f.write(self.packer.pack(record))
+        self.f.flush()
+
+    def close(self):
+        self.f.close()
+
+class BedWriter:
+    def __init__(self, path: str, options: dict, compression: dict = None):
+        self.f = open_text(path, compression)
+        self.header = options["header"]
+        self.na_rep = options["na_rep"] or "."
+
+    def write(self, df: pd.DataFrame):
+        bed = pd.DataFrame({
+            "#chrom": df["chrom"],
+            "start": df["start"],
+            "end": df["end"],
+            "str_id": df["str_id"],
+            "genotype": df["genotype"].map(
+                lambda gt: ",".join(str(a) for a in gt) if isinstance(gt, list) else self.na_rep
+            ),
+            "depth": df["depth"].astype(object).where(df["depth"].notna(), self.na_rep),
+        })
+        # the header is a comment line, which bedtools and genome browsers skip
+        bed.to_csv(self.f, sep="\t", index=False, header=self.header, lineterminator="\n")
+        self.header = False
+        self.f.flush()
+
+    def close(self):
+        self.f.close()
+
+def avro_schema(columns: list) -> dict:
+    fields = []
+    for col in columns:
+        field = schema_field(col)
+        fields.append({
+            "name": col,
+            "type": ["null", AVRO_TYPES[field["type"]]],
+            "default": None,
+            "doc": field["description"],
+        })
+    return {
+        "type": "record",
+        "name": "ConstrainGenotype",
+        "namespace": "constrain",
+        "doc": f"ConSTRain STR genotype, written by csv_from_vcf.py v{VERSION} (output schema v{SCHEMA_VERSION})",
+        "fields": fields,
+    }
+
+class AvroWriter:
+    def __init__(self, path: str, options: dict, compression: dict = None):
+        self.fastavro = optional_module("fastavro", "--format avro")
+        self.f = open(path, "wb")
+        self.writer = None
+
+    def write(self, df: pd.DataFrame):
+        # the schema is based on the columns of the first chunk, every chunk has the same columns
+        if self.writer is None:
+            schema = self.fastavro.parse_schema(avro_schema(list(df.columns)))
+            self.writer = self.fastavro.write.Writer(self.f, schema, codec="deflate")
+        df = stringify_objects(df).astype(object)
+        for record in df.where(df.notna(), None).to_dict("records"):
+            self.writer.write(record)
+        self.writer.flush()
+
+    def close(self):
+        if self.writer is not None:
+            self.writer.flush()
+        self.f.close()
+
+class BedgraphWriter:
+    def __init__(self, path: str, options: dict, compression: dict = None):
+        self.f = open_text(path, compression)
+        if options["header"]:
+            self.f.write('track type=bedGraph name="depth_norm" description="ConSTRain depth / copy number"\n')
+
+    def write(self, df: pd.DataFrame):
+        df = df.loc[df["depth_norm"].notna() & np.isfinite(df["depth_norm"]), ["chrom", "start", "end", "depth_norm"]]
+        df.to_csv(self.f, sep="\t", index=False, header=False, lineterminator="\n")
+        self.f.flush()
+
+    def close(self):
+        self.f.close()
+
+WRITERS = {
+    "csv": CsvWriter,
+    "parquet": ParquetWriter,
+    "jsonl": JsonlWriter,
+    "feather": FeatherWriter,
+    "sqlite": SqliteWriter,
+    "duckdb": DuckdbWriter,
+    "xlsx": XlsxWriter,
+    "bed": BedWriter,
+    "avro": AvroWriter,
+    "msgpack": MsgpackWriter,
+    "orc": OrcWriter,
+    "bedgraph": BedgraphWriter,
+}
+
+class CombinedWriter:
+    # Writes the records of all VCF files to a single output with --combine. Columns can differ between
+    # VCF files (e.g., the FORMAT fields of --passthrough-all), so every chunk is written with the columns
+    # of the first one. Columns that a later VCF file lacks are left empty, extra columns are not written
+    def __init__(self, writer):
+        self.writer = writer
+        self.columns = None
+        self.dropped = set()
+
+    def write(self, df: pd.DataFrame):
+        if self.columns is None:
+            self.columns = list(df.columns)
+        dropped = [col for col in df.columns if col not in self.columns and col not in self.dropped]
+        if dropped:
+            print(f"WARNING: column(s) {dropped} are not in the first VCF file and are not written", file=sys.stderr)
+            self.dropped.update(dropped)
+        self.writer.write(df.reindex(columns=self.columns))
+
+    def close(self):
+        self.writer.close()
+
+def output_columns(df: pd.DataFrame, fmt: str) -> pd.DataFrame:
+    if fmt in BED_FORMATS:
+        return df
+    return df.drop(columns=[col for col in COORDINATE_COLUMNS if col in df.columns])
+
+def write_df(df: pd.DataFrame, path: str, fmt: str, options: dict, compression: dict = None):
+    writer = WRITERS[fmt](path, options, compression)
+    writer.write(df)
+    writer.close()
+
+def write_partitions(
+    df: pd.DataFrame,
+    output_dir: str,
+    sample: str,
+    part: int,
+    keys: list,
+    fmt: str,
+    options: dict,
+    compression: dict = None,
+):
+    # Every chunk is written as a separate part file (with its own header) to each partition it has rows for
+    groups = df.groupby("chrom", sort=False) if "chrom" in keys else [(None, df)]
+    for chrom, group in groups:
+        path = output_dir
+        if chrom is not None:
+            path = os.path.join(path, f"chrom={quote(str(chrom), safe='')}")
+        if "sample" in keys:
+            path = os.path.join(path, f"sample={quote(sample, safe='')}")
+            name = f"part-{part:04d}{output_suffix(fmt, options, compression)}"
+        else:
+            # part files of different samples end up in the same directory
+            name = f"{sample}-part-{part:04d}{output_suffix(fmt, options, compression)}"
+        os.makedirs(path, exist_ok=True)
+        write_df(output_columns(group, fmt), os.path.join(path, name), fmt, options, compression)
+
+def long_layout(df: pd.DataFrame, sample: str) -> pd.DataFrame:
+    # coordinates are kept for partitioning
+    cols = ["str_id"] + [col for col in COORDINATE_COLUMNS if col in df.columns]
+    df = df.loc[df["genotype"].notna(), cols + ["genotype", "frequencies"]]
+    df = df.assign(allele_index=df["genotype"].map(lambda gt: list(range(len(gt)))))
+    df = df.explode(["genotype", "allele_index"]).rename(columns={"genotype": "allele_length"})
+    support = [
+        freqs.get(allele, 0) if isinstance(freqs, dict) else np.nan
+        for allele, freqs in zip(df["allele_length"], df["frequencies"])
+    ]
+    df = (
+        df.assign(support=support, sample=sample)
+        .astype({"allele_length": "Int64", "allele_index": "Int64", "support": "Int64"})
+        .reset_index(drop=True)
+    )
+    return df[LONG_COLUMNS + cols[1:]]
+
+def matrix_df(chunks: dict, samples: list) -> pd.DataFrame:
+    # chunks maps samples to the chunks of their --matrix column, indexed by str_id
+    columns = []
+    for sample_chunks in chunks.values():
+        column = pd.concat(sample_chunks)
+        columns.append(column[~column.index.duplicated()])
+    df = pd.concat(columns, axis=1, keys=list(chunks), sort=False).reindex(columns=samples)
+    df.index.name = "str_id"
+    return df
+
+class MatrixBuilder:
+    # Collects the --matrix column of every sample. Once the chunks held in memory take up more than
+    # `max_memory` bytes, they are spilled to temporary files of MATRIX_BUCKET_LOCI consecutive loci each,
+    # so that write() only needs to hold the rows of one bucket at a time. `order` maps str_ids to the order
+    # in which they were first seen, which keeps the rows in VCF order
+    def __init__(self, max_memory: int = None):
+        self.max_memory = max_memory
+        self.samples = []
+        self.order = dict()
+        self.chunks = dict()
+        self.n_bytes = 0
+        self.spill_dir = None
+
+    def add(self, sample: str, column: pd.Series):
+        if sample not in self.samples:
+            self.samples.append(sample)
+        self.chunks.setdefault(sample, []).append(column)
+        for str_id in column.index:
+            self.order.setdefault(str_id, len(self.order))
+        if self.max_memory is not None:
+            self.n_bytes += int(column.memory_usage(deep=True))
+            if self.n_bytes > self.max_memory:
+                self.spill()
+
+    def spill(self):
+        if self.spill_dir is None:
+            self.spill_dir = tempfile.mkdtemp(prefix="constrain_matrix_")
+            atexit.register(shutil.rmtree, self.spill_dir, True)
+        for sample, chunks in self.chunks.items():
+            column = pd.concat(chunks)
+            buckets = column.index.map(self.order).to_numpy() // MATRIX_BUCKET_LOCI
+            for bucket, part in column.groupby(buckets):
+                with open(os.path.join(self.spill_dir, f"{bucket}.pkl"), "ab") as f:
+                    pickle.dump((sample, part), f)
+        self.chunks = dict()
+        self.n_bytes = 0
+
+    def bucket_chunks(self, bucket: int) -> dict:
+        chunks = dict()
+        path = os.path.join(self.spill_dir, f"{bucket}.pkl")
+        if not os.path.exists(path):
+            return chunks
+        with open(path, "rb") as f:
+            while True:
+                try:
+                    sample, part = pickle.load(f)
+                except EOFError:
+                    return chunks
+                chunks.setdefault(sample, []).append(part)
+
+    def write(self, path: str, options: dict, compression: dict = None):
+        with open_text(path, compression, options["encoding"]) as f:
+            if self.spill_dir is None:
+                df = matrix_df(self.chunks, self.samples)
+                stringify_objects(df).to_csv(f, **{**options, "index": True})
+                return
+            self.spill()
+            header = options["header"]
+            for bucket in range(len(self.order) // MATRIX_BUCKET_LOCI + 1):
+                chunks = self.bucket_chunks(bucket)
+                if not chunks:
+                    continue
+                df = matrix_df(chunks, self.samples).sort_index(key=lambda index: index.map(self.order))
+                stringify_objects(df).to_csv(f, **{**options, "index": True, "header": header})
+                header = False
+
+def convert(
+    files: InputFiles,
+    vcf_file: str,
+    output: str,
+    args,
+    catalog: pd.DataFrame = None,
+    matrix: MatrixBuilder = None,
+    sample: str = None,
+    parts: list = None,
+    writer=None,
+) -> dict:
+    # `parts` are VCF files of the same sample that are converted to the same output after `vcf_file`,
+    # e.g. when ConSTRain was run per chromosome. `writer` is an open writer shared by all VCF files (--combine)
+    start = time.perf_counter()
+    options = csv_options(args)
+    vcf_files = [vcf_file] + (parts or [])
+    report = {
+        "vcf": ",".join(vcf_files),
+        "sample": sample_name(files, vcf_file, sample, args.rename_samples),
+        "output": output,
+        "intermediate_loci": 0,
+        "expanded_loci": 0,
+        "bytes_read": None if any(files.size(f) is None for f in vcf_files) else sum(files.size(f) for f in vcf_files),
+        "records_written": 0,
+    }
+    # with --partition-by, output is the root directory of the partitioned layout
+    partitioned = args.partition_by is not None
+    if partitioned:
+        os.makedirs(output, exist_ok=True)
+    if partitioned:
+        warnings_path = os.path.join(output, f"{report['sample']}.warnings.tsv")
+    elif args.format in DATABASE_FORMATS or args.combine:
+        warnings_path = f"{output}.{report['sample']}.warnings.tsv"
+    else:
+        warnings_path = f"{output}.warnings.tsv"
+    warnings = [] if args.warnings else None
+    warnings_file = None
+    if args.warnings:
+        # with output to stdout, warnings go to stderr
+        warnings_file = open(sys.stderr.fileno(), "w", closefd=False) if output == "-" else open(warnings_path, "w")
+    if warnings_file is not None:
+        warnings_file.write("line\tchrom\tpos\treason\n")
+
+    compression = compression_options(args)
+    shared = writer is not None
+    if writer is None and not partitioned:
+        writer = WRITERS[args.format](output, options, compression)
+    try:
+        # with --limit, no more records than needed are read (in long layout, a record is more than one row)
+        chunksize = min(args.flush_every, args.limit) if args.limit is not None else args.flush_every
+        chunks = (
+            df for f in vcf_files
+            for df in dfs_from_vcf(files, f, chunksize=chunksize, warnings=warnings, sample=sample, **vcf_options(args))
+        )
+        for i, df in enumerate(chunks):
+            df = finalize_df(df, args, catalog)
+            if matrix is not None:
+                matrix.add(report["sample"], df.set_index("str_id")[args.matrix])
+            if args.classify:
+                classes = df["classification"].dropna()
+                report["intermediate_loci"] += int(classes.map(lambda x: "intermediate" in x).sum())
+                report["expanded_loci"] += int(classes.map(lambda x: "expanded" in x).sum())
+            if args.layout == "long":
+                df = long_layout(df, report["sample"])
+            elif args.format in DATABASE_FORMATS or args.combine:
+                df.insert(0, "sample", report["sample"])
+            # after the layout, which decides the columns
+            if args.column_order is not None:
+                df = order_columns(df, args.column_order)
+            if args.limit is not None:
+                df = df.iloc[:args.limit - report["records_written"]]
+            if partitioned:
+                write_partitions(
+                    df, output, report["sample"], i, args.partition_by, args.format, options, compression
+                )
+            else:
+                writer.write(output_columns(df, args.format))
+            report["records_written"] += len(df)
+            if warnings_file is not None:
+                write_warnings(warnings_file, warnings)
+            if args.limit is not None and report["records_written"] >= args.limit:
+                break
+    finally:
+        if writer is not None and not shared:
+            writer.close()
+
+    if warnings_file is not None:
+        # records dropped after the last chunk was yielded
+        write_warnings(warnings_file, warnings)
+        warnings_file.close()
+
+    report["columns"] = [col for col in df.columns if col not in COORDINATE_COLUMNS]
+    report["wall_time_s"] = round(time.perf_counter() - start, 3)
+    # ru_maxrss is the peak of the whole process (in KiB on Linux), so this is an upper bound per file
+    report["peak_rss_mb"] = round(resource.getrusage(resource.RUSAGE_SELF).ru_maxrss / 1024, 1)
+    return report
+
+def print_flagged_samples(reports: list):
+    print("sample\tvcf\tintermediate_loci\texpanded_loci", file=sys.stderr)
+    for report in reports:
+        if report["intermediate_loci"] == 0 and report["expanded_loci"] == 0:
+            continue
+        print(
+            f"{report['sample']}\t{report['vcf']}\t{report['intermediate_loci']}\t{report['expanded_loci']}",
+            file=sys.stderr,
+        )
+
+def count_records(files: InputFiles, vcf_file: str, threads: int = 1, samples: list = None) -> dict:
+    # Maps samples to their counts, or None for a VCF file with one sample. The selected `samples` must be set
+    # for multi-sample VCF files, all of them are counted in one pass so that stdin can be counted as well
+    vcf = files.vcf(vcf_file, threads=threads, **({"samples": samples} if samples is not None else {}))
+    if samples is None and len(vcf.samples) != 1:
+        raise RuntimeError("this script currently only supports analysing VCF files with exactly one sample")
+
+    keys = list(vcf.samples) if samples is not None else [None]
+    counts = {key: {"records": 0} for key in keys}
+    for variant in vcf:
+        ft = variant.format("FT")
+        for i, key in enumerate(keys):
+            tag = f"FT={ft[i]}" if ft is not None else "FT=."
+            counts[key]["records"] += 1
+            counts[key][tag] = counts[key].get(tag, 0) + 1
+
+    return counts
+
+def print_counts(files: InputFiles, vcf_files: list, args):
+    # multi-sample VCF files are counted for every sample, or every --sample in them
+    print("file\tsample\tcategory\tcount")
+    for vcf_file in vcf_files:
+        try:
+            samples = selected_samples(files, vcf_file, args.sample, split=True)
+            counts = count_records(files, vcf_file, args.threads, samples if samples != [None] else None)
+            counts = {sample: counts[sample] for sample in samples}
+        except READ_ERRORS as e:
+            if not args.lenient:
+                raise
+            print(f"WARNING: cannot read {vcf_file} ({e}), skipping file", file=sys.stderr)
+            continue
+        finally:
+            files.release(vcf_file)
+        for sample, sample_counts in counts.items():
+            name = sample_name(files, vcf_file, sample, args.rename_samples)
+            for category in sorted(sample_counts, key=lambda c: (c != "records", c)):
+                print(f"{vcf_file}\t{name}\t{category}\t{sample_counts[category]}")
+
+def print_head(files: InputFiles, vcf_file: str, n: int, args, catalog: pd.DataFrame = None):
+    samples = selected_samples(files, vcf_file, args.sample)
+    if not samples:
+        sys.exit(f"None of the --sample names are in {vcf_file}")
+    df = next(dfs_from_vcf(files, vcf_file, chunksize=n, sample=samples[0], **vcf_options(args)))
+    df = finalize_df(df, args, catalog)
+    # previewed with the columns that are written, see convert
+    if args.layout == "long":
+        df = long_layout(df, sample_name(files, vcf_file, samples[0], args.rename_samples))
+    elif args.format in DATABASE_FORMATS or args.combine:
+        df.insert(0, "sample", sample_name(files, vcf_file, samples[0], args.rename_samples))
+    if args.column_order is not None:
+        df = order_columns(df, args.column_order)
+
+    options = csv_options(args)
+    if args.head_format == "csv":
+        df.to_csv(sys.stdout, **options)
+    else:
+        print(df.to_string(
+            index=False, header=options["header"], na_rep=options["na_rep"], float_format=options["float_format"]
+        ))
+
+def batch_output_dirs(directories: list, output_dir: str) -> dict:
+    if len(directories) == 1:
+        return {directories[0]: output_dir}
+
+    batches = dict()
+    names = set()
+    for directory in directories:
+        name = os.path.basename(os.path.normpath(directory))
+        base, i = name, 1
+        while name in names:
+            i += 1
+            name = f"{base}_{i}"
+        names.add(name)
+        batches[directory] = os.path.join(output_dir, name)
+
+    return batches
+
+def expand_input(
+    files: InputFiles,
+    path: str,
+    extensions: list,
+    pattern: str = None,
+    follow_symlinks: bool = False,
+    exclude: list = None,
+) -> list:
+    # List of (VCF file, directory it was found in) tuples. The directory is None for loose files
+    # stdin and URLs are streamed by htslib (see InputFiles.vcf), using range requests for URLs when an index is used
+    if path == "-" or is_remote(path):
+        return [(path, None)]
+    if os.path.isdir(path):
+        return [(f, path) for f in vcf_files_from_dir(path, extensions, pattern, follow_symlinks, exclude)]
+    # archives are searched like directories, with the archive as the directory of its members
+    if os.path.isfile(path) and is_archive(path):
+        return [(f, path) for f in files.archive_members(path, extensions, pattern, exclude)]
+    if os.path.isfile(path):
+        if matching_extension(path, extensions) is not None or is_vcf_file(files, path):
+            return [(path, None)]
+        # any other file is a manifest listing one input per line
+        msg = f"Input {path} is not a VCF or BCF file, directory, archive, or text file listing inputs"
+        if not is_text_file(files, path):
+            sys.exit(msg)
+        try:
+            with open(path, encoding="utf-8") as f:
+                lines = [line.strip() for line in f if line.strip() and not line.startswith("#")]
+        except UnicodeDecodeError:
+            sys.exit(msg)
+        return [
+            entry for line in lines
+            for entry in expand_input(files, line, extensions, pattern, follow_symlinks, exclude)
+        ]
+
+    matches = sorted(glob.glob(path, recursive=True))
+    if not matches:
+        sys.exit(f"Input {path} is not a file, directory, or glob pattern matching any files")
+    return [
+        entry for match in matches
+        for entry in expand_input(files, match, extensions, pattern, follow_symlinks, exclude)
+    ]
+
+def sample_name(files: InputFiles, vcf_file: str, sample: str = None, renames: dict = None) -> str:
+    # name of the (selected) sample of a VCF file in the output, see --rename-samples and --duplicate-samples
+    name = sample if sample is not None else files.samples(vcf_file)[0]
+    renamed = (renames or dict()).get(name, name)
+    if (vcf_file, name) in files.sample_suffixes:
+        renamed += f"_{files.sample_suffixes[(vcf_file, name)]}"
+    return renamed
+
+def selected_samples(files: InputFiles, vcf_file: str, samples: list, split: bool = False) -> list:
+    # None converts the VCF file as is, which is only possible if it has exactly one sample.
+    # Multi-sample VCF files are converted once for every selected sample in them, or for all with `split`
+    if not samples and not split:
+        return [None]
+    names = files.samples(vcf_file)
+    if len(names) == 1:
+        return [None] if not samples or names[0] in samples else []
+    return [sample for sample in names if not samples or sample in samples]
+
+def read_fofn(fofn: str) -> dict:
+    # Maps VCF files to the output name in the second column, or None if there is none
+    entries = dict()
+    with open(fofn) as f:
+        for line in f:
+            if not line.strip() or line.startswith("#"):
+                continue
+            fields = line.rstrip("\r\n").split("\t")
+            entries[fields[0].strip()] = fields[1].strip() if len(fields) > 1 and fields[1].strip() else None
+    return entries
+
+def renamed_output(path: str, sample: str, renames: dict) -> str:
+    # outputs of VCF files that are named after their sample get the name of the renamed sample
+    directory, name = os.path.split(path)
+    if sample in renames and name.startswith((f"{sample}.", f"{sample}_")):
+        return os.path.join(directory, renames[sample] + name[len(sample):])
+    return path
+
+def fofn_output(name: str, output_dir: str, suffix: str, sample: str = None) -> str:
+    if not os.path.splitext(name)[1]:
+        name += suffix
+    # samples selected from multi-sample VCF files each get their own file, like in csv_path_for_vcf
+    if sample is not None:
+        stem = name[:-len(suffix)] if name.endswith(suffix) else os.path.splitext(name)[0]
+        name = f"{stem}.{sample}{name[len(stem):]}"
+    return os.path.join(output_dir, name)
+
+def input_vcf_files(args, files: InputFiles) -> tuple:
+    # Returns the (VCF file, directory) tuples to convert and whether a single VCF file was given,
+    # in which case --output is a file rather than a directory
+    extensions = parse_extensions(args.extensions)
+    inputs = [(f, None) for f in args.vcf]
+    inputs += [
+        entry for d in args.directory
+        for entry in expand_input(files, d, extensions, args.pattern, args.follow_symlinks, args.exclude)
+    ]
+    positional = [
+        expand_input(files, path, extensions, args.pattern, args.follow_symlinks, args.exclude) for path in args.inputs
+    ]
+    inputs += [entry for entries in positional for entry in entries]
+    if args.fofn is not None:
+        inputs += [(f, None) for f in read_fofn(args.fofn)]
+    single_file = (
+        args.fofn is None
+        and len(args.vcf + args.directory + args.inputs) == 1
+        and len(inputs) == 1
+        and not args.directory
+        # a manifest, directory, or glob pattern that expands to a single VCF file is not a single file
+        and (args.vcf or args.inputs[0] == "-" or positional[0] == [(args.inputs[0], None)])
+    )
+    # with --watch, duplicates are only looked for among VCF files that are no longer being written.
+    # With --merge-split, VCF files of the same sample are expected
+    if single_file or args.watch is not None or args.merge_split:
+        return inputs, single_file
+
+    keep = set(deduplicate_samples(files, [f for f, _ in inputs], args.duplicate_samples, args.lenient))
+    return [(f, d) for f, d in inputs if f in keep], False
+
+def file_stat(files: InputFiles, path: str) -> tuple:
+    if is_remote(path):
+        return None
+    # archive members change when their archive does
+    try:
+        st = os.stat(files.archive(path) if files.is_member(path) else path)
+    except FileNotFoundError:
+        return None
+    return files.size(path), st.st_mtime_ns
+
+def watched_inputs(args, files: InputFiles, converted: dict, previous: dict, seen: dict) -> list:
+    # (VCF file, directory) tuples that are new or modified since they were converted, and did not
+    # change since the previous check. `converted` and `previous` map VCF files to their file_stat,
+    # `seen` maps samples to the VCF files they were converted from, see deduplicate_samples
+    inputs, _ = input_vcf_files(args, files)
+    stats = {f: file_stat(files, f) for f, _ in inputs}
+    ready = [
+        (f, d) for f, d in inputs
+        if f in previous and stats[f] == previous[f] and (f not in converted or converted[f] != stats[f])
+    ]
+    previous.clear()
+    previous.update(stats)
+    keep = set(deduplicate_samples(files, [f for f, _ in ready], args.duplicate_samples, args.lenient, seen))
+    for f, _ in ready:
+        converted[f] = stats[f]
+    return [(f, d) for f, d in ready if f in keep]
+
+def first_locus(files: InputFiles, vcf_file: str) -> tuple:
+    # (index of the contig in the header, contig, position) of the first record, to order VCF files split by contig
+    header = text_header(files, vcf_file) if files.is_member(vcf_file) else None
+    if header is not None:
+        contigs, _, record = header
+        if record is None:
+            return len(contigs), "", 0
+        chrom, pos = record
+        return contigs.index(chrom) if chrom in contigs else len(contigs), chrom, pos
+    vcf = files.vcf(vcf_file)
+    variant = next(iter(vcf), None)
+    if variant is None:
+        return len(vcf.seqnames), "", 0
+    contigs = list(vcf.seqnames)
+    return contigs.index(variant.CHROM) if variant.CHROM in contigs else len(contigs), variant.CHROM, variant.POS
+
+def group_split_vcfs(files: InputFiles, inputs: list) -> tuple:
+    # Groups (VCF file, directory) tuples by the samples in the VCF files and orders every group by its
+    # first record. Returns the first tuple of every group, and a dict mapping its VCF file to the others
+    groups = dict()
+    for f, d in inputs:
+        groups.setdefault(tuple(files.samples(f)), []).append((f, d))
+    firsts = []
+    parts = dict()
+    for group in groups.values():
+        group.sort(key=lambda entry: first_locus(files, entry[0]))
+        firsts.append(group[0])
+        parts[group[0][0]] = [f for f, _ in group[1:]]
+    return firsts, parts
+
+def input_rounds(args, files: InputFiles, inputs: list):
+    # Yields the inputs to convert once, or with --watch whenever VCF files are ready, until Ctrl-C
+    if args.watch is None:
+        yield inputs
+        return
+    converted = dict()
+    previous = dict()
+    seen = dict()
+    try:
+        while True:
+            ready = watched_inputs(args, files, converted, previous, seen)
+            if ready:
+                yield ready
+            time.sleep(args.watch)
+    except KeyboardInterrupt:
+        return
+
+def file_digest(files: InputFiles, path: str, algorithm: str) -> str:
+    # remote files are not downloaded just to compute their checksum
+    if is_remote(path):
+        return None
+    digest = hashlib.new(algorithm)
+    with files.open(path) as f:
+        for block in iter(lambda: f.read(1 << 20), b""):
+            digest.update(block)
+    return digest.hexdigest()
+
+def file_sha256(files: InputFiles, path: str) -> str:
+    return file_digest(files, path, "sha256")
+
+def files_sha256(files: InputFiles, paths: list) -> str:
+    # checkpoint digest of the VCF files converted to one output, see --merge-split
+    return ",".join(str(file_sha256(files, path)) for path in paths)
+
+def read_checksums(manifest: str) -> dict:
+    # Maps absolute paths to (algorithm, checksum) tuples, from md5sum, sha1sum, or sha256sum output.
+    # Paths are relative to the directory of the manifest
+    checksums = dict()
+    base = os.path.dirname(os.path.abspath(manifest))
+    with open(manifest) as f:
+        for line_no, line in enumerate(f, start=1):
+            if not line.strip() or line.startswith("#"):
+                continue
+            m = re.match(r"([0-9a-fA-F]+) [ *](.+)$", line.rstrip("\r\n"))
+            if m is None or len(m.group(1)) not in CHECKSUM_ALGORITHMS:
+                raise ValueError(
+                    f"{manifest}:{line_no}: expected '<checksum>  <file>', with an md5, sha1, or sha256 checksum"
+                )
+            path = os.path.normpath(os.path.join(base, m.group(2)))
+            checksums[path] = (CHECKSUM_ALGORITHMS[len(m.group(1))], m.group(1).lower())
+    return checksums
+
+def verify_checksum(files: InputFiles, vcf_file: str, checksums: dict) -> str:
+    # Returns a message if `vcf_file` does not match its checksum, or is not listed
+    expected = checksums.get(os.path.abspath(vcf_file))
+    if expected is None:
+        return f"{vcf_file} is not listed in --checksums"
+    algorithm, checksum = expected
+    actual = file_digest(files, vcf_file, algorithm)
+    if actual != checksum:
+        return f"{algorithm} checksum of {vcf_file} is {actual}, expected {checksum}"
+    return None
+
+def checksum_ok(files: InputFiles, vcf_file: str, checksums: dict, action: str) -> bool:
+    if checksums is None:
+        return True
+    # remote files are not downloaded just to compute their checksum
+    if is_remote(vcf_file):
+        print(f"WARNING: {vcf_file} is a remote file, its checksum is not verified", file=sys.stderr)
+        return True
+    msg = verify_checksum(files, vcf_file, checksums)
+    if msg is None:
+        return True
+    if action == "error":
+        sys.exit(f"ERROR: {msg}")
+    print(f"WARNING: {msg}" + (", skipping file" if action == "skip" else ""), file=sys.stderr)
+    return action == "warn"
+
+def read_checkpoint(checkpoint_file: str) -> dict:
+    if checkpoint_file is None or not os.path.exists(checkpoint_file):
+        return {"completed": dict()}
+    with open(checkpoint_file) as f:
+        return json.load(f)
+
+def write_checkpoint(checkpoint_file: str, checkpoint: dict):
+    # write to a temporary file first so that a killed job never leaves a truncated checkpoint
+    tmp = f"{checkpoint_file}.tmp"
+    with open(tmp, "w") as f:
+        json.dump(checkpoint, f, indent=4)
+    os.replace(tmp, checkpoint_file)
+
+def checkpoint_key(vcf_file: str, sample: str = None) -> str:
+    return vcf_file if sample is None else f"{vcf_file}:{sample}"
+
+def is_completed(files: InputFiles, checkpoint: dict, vcf_file: str, sample: str = None, parts: list = None) -> bool:
+    done = checkpoint["completed"].get(checkpoint_key(vcf_file, sample))
+    return (
+        done is not None
+        and os.path.exists(done["report"]["output"])
+        and done["sha256"] == files_sha256(files, [vcf_file] + (parts or []))
+    )
+
+def is_up_to_date(files: InputFiles, output: str, vcf_file: str, newer_only: bool = False) -> bool:
+    if not os.path.exists(output):
+        return False
+    if not newer_only or is_remote(vcf_file) or vcf_file == "-":
+        return True
+    # archive members have no modification time of their own on disk, the archive's is used instead
+    source = files.archive(vcf_file) if files.is_member(vcf_file) else vcf_file
+    return os.path.getmtime(output) >= os.path.getmtime(source)
+
+def read_index(output_dir: str) -> dict:
+    # Maps output files to their row in the index file of an earlier run, in the same form as reports
+    path = os.path.join(output_dir, INDEX_FILE)
+    if not os.path.exists(path):
+        return dict()
+    with open(path) as f:
+        return {row["csv"]: dict(row, output=row["csv"]) for row in csv.DictReader(f, delimiter="\t")}
+
+def write_index(output_dir: str, reports: list):
+    with open(os.path.join(output_dir, INDEX_FILE), "w") as f:
+        f.write("sample\tbatch\tvcf\tcsv\twall_time_s\tbytes_read\trecords_written\tpeak_rss_mb\n")
+        for report in reports:
+            f.write(
+                f"{report['sample']}\t{report['batch']}\t{report['vcf']}\t{report['output']}\t"
+                f"{report['wall_time_s']}\t{report['bytes_read']}\t{report['records_written']}\t"
+                f"{report['peak_rss_mb']}\n"
+            )
+
 def main():
     args = parse_cla()
+    files = InputFiles()
+    inputs, single_file = input_vcf_files(args, files)
+    vcf_files = [vcf_file for vcf_file, _ in inputs]
+
+    if args.schema is not None:
+        passthrough = parse_tags(args.passthrough_format)
+        if args.passthrough_all:
+            # the FORMAT fields differ between VCF files, so those of the first one are listed
+            if not vcf_files:
+                sys.exit("--schema with --passthrough-all needs a VCF file to read the FORMAT fields from")
+            passthrough = format_tags(files.vcf(vcf_files[0]))
+        columns = output_column_names(args, passthrough)
+        if args.column_order is not None:
+            columns = ordered_columns(columns, args.column_order)
+        print_schema(args.schema, columns, args.schema_version)
+        return
+    catalog = read_catalog(args.catalog) if args.catalog is not None else None
+    checksums = read_checksums(args.checksums) if args.checksums is not None else None
+
+    if args.validate_strict:
+        n_errors = 0
+        for vcf_file in vcf_files:
+            for line_no, msg in validate_vcf(files, vcf_file):
+                print(f"{vcf_file}:{line_no}: {msg}", file=sys.stderr)
+                n_errors += 1
+        if n_errors > 0:
+            sys.exit(f"Found {n_errors} VCF specification violation(s), nothing was converted")
+
+    if args.count or args.head:
+        if args.count:
+            print_counts(files, vcf_files, args)
+        elif vcf_files:
+            print_head(files, vcf_files[0], args.head, args, catalog)
+        return
+
+    if args.output == "-" and not single_file and not args.combine:
+        sys.exit("--output - can only be used with a single VCF file or --combine")
+    if args.watch is not None and single_file:
+        sys.exit("--watch can only be used with directories, glob patterns, or files listing inputs")
+    if single_file and args.partition_by is None and args.matrix is None and not args.split_samples:
+        vcf_file = vcf_files[0]
+        samples = selected_samples(files, vcf_file, args.sample)
+        if len(samples) != 1:
+            sys.exit(f"{len(samples)} of the --sample names are in {vcf_file}, expected exactly one")
+        if not checksum_ok(files, vcf_file, checksums, args.checksum_action):
+            return
+        report = convert(files, vcf_file, args.output, args, catalog, sample=samples[0])
+        if args.datapackage:
+            package_dir = os.path.dirname(os.path.abspath(args.output))
+            resource = datapackage_resource(
+                vcf_file, os.path.abspath(args.output), package_dir, report["columns"], csv_options(args)
+            )
+            write_datapackage(package_dir, [resource])
+        if args.classify:
+            print_flagged_samples([report])
+        return
+
+    reports = dict()
+    resources = dict()
+    matrix = MatrixBuilder(args.max_memory) if args.matrix is not None else None
+    fofn_names = read_fofn(args.fofn) if args.fofn is not None else dict()
+    extensions = parse_extensions(args.extensions)
+    directories = list(dict.fromkeys(d for _, d in inputs if d is not None))
+    batches = batch_output_dirs(directories, args.output)
+    batches[None] = args.output
+    checkpoint = read_checkpoint(args.resume_from)
+    checkpoint_file = args.checkpoint if args.checkpoint is not None else args.resume_from
+    parts = dict()
+    if args.merge_split:
+        inputs, parts = group_split_vcfs(files, inputs)
+    previous_index = read_index(args.output) if args.skip_existing or args.newer_only else dict()
+    combined = None
+    if args.combine:
+        combined = CombinedWriter(WRITERS[args.format](args.output, csv_options(args), compression_options(args)))
+    for round_inputs in input_rounds(args, files, inputs):
+        # every part of a VCF file that was split by contig is checked, not only the first
+        inputs = [
+            (f, d) for f, d in round_inputs
+            if all([checksum_ok(files, p, checksums, args.checksum_action) for p in [f] + parts.get(f, [])])
+        ]
+        jobs = []
+        for f, d in inputs:
+            try:
+                jobs += [(f, d, sample) for sample in selected_samples(files, f, args.sample, args.split_samples)]
+            except READ_ERRORS as e:
+                if not args.lenient:
+                    raise
+                print(f"WARNING: cannot read {f} ({e}), skipping file", file=sys.stderr)
+        if len([job for job in jobs if job[0] == "-"]) > 1:
+            sys.exit("Only one sample of a VCF file read from stdin can be converted, select it with --sample")
+        new_reports = []
+        for i, (vcf_file, directory, sample) in enumerate(jobs):
+            # the jobs of a VCF file are consecutive, so its archive members are no longer needed
+            if i > 0 and jobs[i - 1][0] != vcf_file:
+                release_input(files, jobs[i - 1][0], parts)
+            key = checkpoint_key(vcf_file, sample)
+            vcf_parts = [vcf_file] + parts.get(vcf_file, [])
+            if is_completed(files, checkpoint, vcf_file, sample, parts.get(vcf_file)):
+                report = checkpoint["completed"][key]["report"]
+                print(f"Skipping {vcf_file}, already converted to {report['output']}", file=sys.stderr)
+                reports[key] = report
+                new_reports.append(report)
+                resources[key] = datapackage_resource(
+                    vcf_file, report["output"], args.output, report["columns"], csv_options(args)
+                )
+                continue
 
-    df = df_from_vcf(args.vcf)
+            suffix = output_suffix(args.format, csv_options(args), compression_options(args))
+            if args.partition_by is not None or args.format in DATABASE_FORMATS or args.combine:
+                output = args.output
+            elif fofn_names.get(vcf_file) is not None:
+                renamed = sample_name(files, vcf_file, sample, args.rename_samples) if sample is not None else None
+                output = fofn_output(fofn_names[vcf_file], args.output, suffix, renamed)
+                os.makedirs(os.path.dirname(output), exist_ok=True)
+            else:
+                renamed = sample_name(files, vcf_file, sample, args.rename_samples) if sample is not None else None
+                output = csv_path_for_vcf(vcf_file, directory, batches[directory], extensions, suffix, renamed)
+                if sample is None and args.rename_samples:
+                    output = renamed_output(output, files.samples(vcf_file)[0], args.rename_samples)
+                if args.merge_split or args.split_samples or vcf_file == "-":
+                    # named after the sample rather than after the (first) VCF file, or stdin
+                    name = sample_name(files, vcf_file, sample, args.rename_samples)
+                    output = os.path.join(os.path.dirname(output), name + suffix)
+                os.makedirs(os.path.dirname(output), exist_ok=True)
+            if (args.skip_existing or args.newer_only) and all(
+                is_up_to_date(files, output, f, args.newer_only) for f in vcf_parts
+            ):
+                print(f"Skipping {vcf_file}, {output} already exists", file=sys.stderr)
+                # keep the index complete, using the statistics of the earlier run if they are known
+                reports[key] = previous_index.get(output) or {
+                    "sample": sample_name(files, vcf_file, sample, args.rename_samples),
+                    "batch": directory, "vcf": ",".join(vcf_parts), "output": output, "wall_time_s": None,
+                    "bytes_read": None, "records_written": None, "peak_rss_mb": None,
+                }
+                continue
+            try:
+                report = convert(files, vcf_file, output, args, catalog, matrix, sample, parts.get(vcf_file), combined)
+            except READ_ERRORS as e:
+                if not args.lenient:
+                    raise
+                print(f"WARNING: converting {vcf_file} failed ({e}), skipping file", file=sys.stderr)
+                # remove the incomplete output file, shared outputs keep the records written so far
+                if output != args.output and os.path.exists(output):
+                    os.remove(output)
+                continue
+            report["batch"] = directory
+            # with --watch, a modified VCF file replaces the report of its earlier conversion
+            reports[key] = report
+            new_reports.append(report)
+            if checkpoint_file is not None:
+                checkpoint["completed"][key] = {"sha256": files_sha256(files, vcf_parts), "report": report}
+                write_checkpoint(checkpoint_file, checkpoint)
+            resources[key] = datapackage_resource(vcf_file, output, args.output, report["columns"], csv_options(args))
+        # including the members of VCF files that were skipped
+        for f, _ in round_inputs:
+            release_input(files, f, parts)
 
-    df.to_csv(args.output, index=False, header=True)
+        # with database formats and --combine, --output is a file rather than a directory
+        if args.format in DATABASE_FORMATS or args.combine:
+            if args.output != "-":
+                write_index(os.path.dirname(os.path.abspath(args.output)), list(reports.values()))
+        else:
+            write_index(args.output, list(reports.values()))
+        if args.datapackage:
+            write_datapackage(args.output, list(resources.values()))
+        if matrix is not None:
+            options = csv_options(args)
+            compression = compression_options(args)
+            path = os.path.join(args.output, f"matrix_{args.matrix}{output_suffix('csv', options, compression)}")
+            matrix.write(path, options, compression)
+        if args.classify:
+            print_flagged_samples(new_reports)
+    if combined is not None:
+        combined.close()
 
 if __name__ == "__main__":
     main()
@@ -62,6 +62,15 @@ def parse_cla():
         help="Used to calculate bounds for loci to include. Lower bound will be at quantile alpha/2, upper bound at quantile 1 - (alpha/2). \
             (NOTE: lower bound will always be at least 1., as it is impossible to estimate a genotype with fewer reads than there are alleles)"
     )
+    skip_tags = parser.add_mutually_exclusive_group()
+    skip_tags.add_argument(
+        "--skip-tags", type=str, default=",".join(VCF_SKIP_TAGS),
+        help=f"Comma-separated FT values of loci that are not included (default: {','.join(VCF_SKIP_TAGS)})"
+    )
+    skip_tags.add_argument(
+        "--no-skip-tags", action="store_const", const="", dest="skip_tags",
+        help="Include loci regardless of their FT value"
+    )
     parser.add_argument(
         "--include_mononuc", action="store_true",
         help="Include mononucleotide repeats when determining bounds. \
@@ -72,7 +81,7 @@ def parse_cla():
 
     return parser.parse_args()
 
-def df_from_vcf(vcf_file: str) -> pd.DataFrame:
+def df_from_vcf(vcf_file: str, skip_tags: list = VCF_SKIP_TAGS) -> pd.DataFrame:
     vcf = VCF(vcf_file)
     if len(vcf.samples) != 1:
         raise RuntimeError("this script currently only supports analysing VCF files with exactly one sample")
@@ -83,7 +92,7 @@ def df_from_vcf(vcf_file: str) -> pd.DataFrame:
     }
 
     for variant in vcf:
-        if any([variant.format("FT")[0] == tag for tag in VCF_SKIP_TAGS]):
+        if any([variant.format("FT")[0] == tag for tag in skip_tags]):
             continue
         df["period"].append(variant.INFO.get("PERIOD"))
         df["copy_number"].append(variant.format("CN")[0][0])
@@ -100,7 +109,8 @@ def main():
 
     print(f"Parsing VCF file {args.vcf}")
     start = time.time()    
-    df = df_from_vcf(args.vcf).dropna()
+    skip_tags = [tag.strip() for tag in args.skip_tags.split(",") if tag.strip()]
+    df = df_from_vcf(args.vcf, skip_tags).dropna()
     print(f"Read VCF file in {time.time() - start:.2f} seconds")    
 
     if args.include_mononuc:
@@ -0,0 +1,229 @@
+#!/usr/bin/env python3
+import argparse
+from collections import Counter
+import os
+
+import numpy as np
+
+VERSION = "1.0.0"
+
+# Repeat units that loci are drawn from, periods 1 to 6
+UNITS = ("A", "AC", "AAT", "AAAG", "AAAAC", "AAAAAG")
+
+# FT values of loci that ConSTRain could not genotype
+SKIP_TAGS = ("DPZERO", "DPOOR", "CNZERO", "CNOOR", "CNMISSING", "AMBGT")
+
+CONTIG_LENGTH = 10_000_000
+
+# Minimum distance between the start positions of two simulated loci
+MIN_SPACING = 400
+
+# Header lines in the order that ConSTRain writes them
+VCF_INFO_LINES = [
+    '##INFO=<ID=END,Number=1,Type=Integer,Description="End position of reference allele">',
+    '##INFO=<ID=RU,Number=1,Type=String,Description="Repeat unit">',
+    '##INFO=<ID=PERIOD,Number=1,Type=Integer,Description="Repeat period (length of unit)">',
+    '##INFO=<ID=REF,Number=1,Type=Float,Description="Repeat allele length in reference">',
+]
+
+VCF_FORMAT_LINES = [
+    '##FORMAT=<ID=GT,Number=1,Type=String,Description="Genotype">',
+    '##FORMAT=<ID=FT,Number=1,Type=String,Description="Filter tag. Contains PASS if all filters passed, otherwise reason for filter">',
+    '##FORMAT=<ID=CN,Number=1,Type=Integer,Description="Copy number">',
+    '##FORMAT=<ID=DP,Number=1,Type=Integer,Description="Number of fully spanning reads mapped to locus">',
+    '##FORMAT=<ID=FREQS,Number=1,Type=String,Description="Frequencies observed for each allele length. Keys are allele lengths and values are the number of reads with that allele length.">',
+    '##FORMAT=<ID=REPLEN,Number=1,Type=String,Description="Genotype given in the number of times the unit is repeated for each allele">',
+]
+
+VCF_FILTER_LINES = [
+    '##FILTER=<ID=PASS,Description="All filters passed">',
+    '##FILTER=<ID=UNDEF,Description="Undefined ConSTRain filter">',
+    '##FILTER=<ID=DPZERO,Description="No reads were mapped to locus">',
+    '##FILTER=<ID=DPOOR,Description="Normalised depth of coverage at locus was out of range specified by --min-norm-depth and --max-norm-depth command line arguments">',
+    '##FILTER=<ID=CNZERO,Description="Copy number was zero">',
+    '##FILTER=<ID=CNOOR,Description="Copy number was out of range specified by --max-cn command line argument">',
+    '##FILTER=<ID=CNMISSING,Description="No copy number set for locus. Can happen if contig is missing from karyotype or if only a part of the STR is affected by a CNA">',
+    '##FILTER=<ID=AMBGT,Description="Multiple genotypes are equally likely">',
+]
+
+DESCRIPTION="\
+description:\n\
+    Generate synthetic VCF files that look like ConSTRain output, one per sample.\n\
+    All samples share the same set of loci, spread evenly over a number of contigs.\n\
+    Per sample, loci get a copy number (mostly 2, optionally altered with --cnv-rate),\n\
+    a genotype close to the reference length (optionally expanded with --expansion-rate),\n\
+    a Poisson distributed depth, and allele length frequencies with some stutter.\n\
+    A fraction of loci (--skip-rate) gets a random ConSTRain filter tag instead of a genotype.\n\
+    The output is meant for testing conversion and QC pipelines, not for benchmarking genotyping.\
+"
+
+def rate(s: str) -> float:
+    val = float(s)
+    if not 0 <= val <= 1:
+        raise argparse.ArgumentTypeError("must be between 0 and 1")
+    return val
+
+def positive_int(s: str) -> int:
+    val = int(s)
+    if val < 1:
+        raise argparse.ArgumentTypeError("must be at least 1")
+    return val
+
+def parse_cla():
+    parser = argparse.ArgumentParser(
+        formatter_class=argparse.RawDescriptionHelpFormatter,
+        description=DESCRIPTION,
+        epilog=f"Script version v{VERSION}",
+    )
+
+    parser.add_argument(
+        "-o", "--output", type=str, required=True,
+        help="Directory where the VCF files (sample_0001.vcf, sample_0002.vcf, ...) will be written"
+    )
+    parser.add_argument(
+        "--samples", type=positive_int, default=1,
+        help="Number of samples (VCF files) to generate (default: 1)"
+    )
+    parser.add_argument(
+        "--loci", type=positive_int, default=1000,
+        help="Number of STR loci per sample (default: 1000)"
+    )
+    parser.add_argument(
+        "--contigs", type=positive_int, default=3,
+        help=f"Number of contigs (chr1, chr2, ...) of {CONTIG_LENGTH} bp that loci are spread over (default: 3)"
+    )
+    parser.add_argument(
+        "--mean-depth", type=float, default=30.,
+        help="Mean number of reads per locus for a diploid locus, scaled with copy number (default: 30)"
+    )
+    parser.add_argument(
+        "--skip-rate", type=rate, default=0.05,
+        help="Fraction of loci that get a filter tag other than PASS and no genotype (default: 0.05)"
+    )
+    parser.add_argument(
+        "--expansion-rate", type=rate, default=0.01,
+        help="Fraction of alleles that are expanded to about three times the reference length (default: 0.01)"
+    )
+    parser.add_argument(
+        "--cnv-rate", type=rate, default=0.,
+        help="Fraction of loci with a copy number of 1, 3, or 4 instead of 2 (default: 0)"
+    )
+    parser.add_argument(
+        "--seed", type=int,
+        help="Seed for the random number generator, to generate the same VCF files on every run"
+    )
+
+    args = parser.parse_args()
+    if args.loci > args.contigs * CONTIG_LENGTH // MIN_SPACING:
+        parser.error(f"too many loci for {args.contigs} contig(s), use more --contigs")
+
+    return args
+
+def simulate_loci(rng: np.random.Generator, n_loci: int, n_contigs: int) -> list:
+    loci = []
+    per_contig = np.array_split(np.arange(n_loci), n_contigs)
+    for contig, idx in enumerate(per_contig, start=1):
+        if len(idx) == 0:
+            continue
+        spacing = CONTIG_LENGTH // len(idx)
+        for i in range(len(idx)):
+            unit = UNITS[rng.integers(len(UNITS))]
+            loci.append({
+                "chrom": f"chr{contig}",
+                # 0-based, leaving room for the reference allele before the next locus
+                "start": i * spacing + int(rng.integers(0, spacing - MIN_SPACING // 2)),
+                "unit": unit,
+                "ref_len": int(rng.integers(5, 21)),
+            })
+
+    return loci
+
+def simulate_genotype(rng: np.random.Generator, ref_len: int, cn: int, expansion_rate: float) -> list:
+    alleles = ref_len + rng.integers(-2, 3, size=cn)
+    expanded = rng.random(cn) < expansion_rate
+    alleles[expanded] = ref_len * 3 + rng.integers(0, 10, size=expanded.sum())
+    return sorted(int(a) for a in np.maximum(alleles, 1))
+
+def simulate_freqs(rng: np.random.Generator, genotype: list, depth: int) -> dict:
+    # every read comes from a random allele and is off by one repeat unit 10% of the time
+    reads = rng.choice(genotype, size=depth) + rng.choice([-1, 0, 1], size=depth, p=[0.05, 0.9, 0.05])
+    return dict(sorted(Counter(int(r) for r in np.maximum(reads, 1)).items()))
+
+def vcf_record(rng: np.random.Generator, locus: dict, args) -> str:
+    unit, ref_len = locus["unit"], locus["ref_len"]
+    period = len(unit)
+    cn = 2
+    if rng.random() < args.cnv_rate:
+        cn = int(rng.choice([1, 3, 4]))
+    ft = SKIP_TAGS[rng.integers(len(SKIP_TAGS))] if rng.random() < args.skip_rate else "PASS"
+    if ft == "CNZERO":
+        cn = 0
+
+    depth = 0 if ft == "DPZERO" else int(rng.poisson(args.mean_depth * max(cn, 1) / 2))
+    if depth == 0 and ft == "PASS":
+        ft = "DPZERO"
+
+    alleles = [unit * ref_len]
+    gt = "."
+    replen = ""
+    freqs = dict()
+    if depth > 0:
+        genotype = simulate_genotype(rng, ref_len, max(cn, 1), args.expansion_rate)
+        freqs = simulate_freqs(rng, genotype, depth)
+        if ft == "PASS":
+            # the reference allele is always first, like in ConSTRain output
+            indices = [0 for length in genotype if length == ref_len]
+            for length in dict.fromkeys(genotype):
+                if length == ref_len:
+                    continue
+                alleles.append(unit * length)
+                indices += [len(alleles) - 1] * genotype.count(length)
+            gt = "/".join(str(i) for i in indices)
+            replen = ",".join(str(length) for length in genotype)
+
+    # ConSTRain does not write the CN field for loci with a missing copy number
+    fields = {"GT": gt, "FT": ft, "CN": str(cn), "DP": str(depth)}
+    if ft == "CNMISSING":
+        del fields["CN"]
+    fields["FREQS"] = "|".join(f"{length},{n}" for length, n in freqs.items()) or "."
+    fields["REPLEN"] = replen or "."
+
+    info = f"END={locus['start'] + period * ref_len};RU={unit};PERIOD={period};REF={ref_len}"
+    return "\t".join([
+        locus["chrom"],
+        str(locus["start"] + 1),
+        ".",
+        alleles[0],
+        ",".join(alleles[1:]) or ".",
+        ".",
+        ".",
+        info,
+        ":".join(fields),
+        ":".join(fields.values()),
+    ])
+
+def vcf_header(sample: str, n_contigs: int) -> list:
+    lines = ["##fileformat=VCFv4.2"]
+    lines += [f"##contig=<ID=chr{i},length={CONTIG_LENGTH}>" for i in range(1, n_contigs + 1)]
+    lines += VCF_INFO_LINES + VCF_FORMAT_LINES + VCF_FILTER_LINES
+    lines.append("\t".join(["#CHROM", "POS", "ID", "REF", "ALT", "QUAL", "FILTER", "INFO", "FORMAT", sample]))
+    return lines
+
+def main():
+    args = parse_cla()
+    rng = np.random.default_rng(args.seed)
+    os.makedirs(args.output, exist_ok=True)
+
+    loci = simulate_loci(rng, args.loci, args.contigs)
+    for i in range(1, args.samples + 1):
+        sample = f"sample_{i:04d}"
+        path = os.path.join(args.output, f"{sample}.vcf")
+        with open(path, "w") as f:
+            for line in vcf_header(sample, args.contigs):
+                f.write(line + "\n")
+            for locus in loci:
+                f.write(vcf_record(rng, locus, args) + "\n")
+        print(f"Wrote {len(loci)} loci to {path}")
+
+if __name__ == "__main__":
+    main()
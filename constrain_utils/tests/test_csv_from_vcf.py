@@ -0,0 +1,717 @@
+#!/usr/bin/env python3
+# Smoke tests that generate a small cohort with simulate_vcf.py and convert it with csv_from_vcf.py,
+# and unit tests of the helpers of csv_from_vcf.py.
+# Run from the repository root with `python -m unittest discover -s constrain_utils/tests`
+import argparse
+import hashlib
+import importlib.util
+import io
+import json
+import math
+import os
+import sqlite3
+import subprocess
+import sys
+import tarfile
+import tempfile
+import types
+import unittest
+import zipfile
+from unittest import mock
+
+UTILS_DIR = os.path.dirname(os.path.dirname(os.path.abspath(__file__)))
+SIMULATE = os.path.join(UTILS_DIR, "simulate_vcf.py")
+CSV_FROM_VCF = os.path.join(UTILS_DIR, "csv_from_vcf.py")
+# small VCF files of the callers supported by --caller
+DATA_DIR = os.path.join(UTILS_DIR, "tests", "data")
+
+N_SAMPLES = 3
+N_LOCI = 200
+
+HAS_DEPENDENCIES = all(importlib.util.find_spec(m) is not None for m in ("cyvcf2", "numpy", "pandas"))
+HAS_PANDAS = all(importlib.util.find_spec(m) is not None for m in ("numpy", "pandas"))
+
+if HAS_PANDAS:
+    # cyvcf2 is only needed to read VCF files, the helpers that take records are tested with FakeVariant
+    if importlib.util.find_spec("cyvcf2") is None:
+        sys.modules["cyvcf2"] = types.SimpleNamespace(VCF=None)
+    sys.path.insert(0, UTILS_DIR)
+    import csv_from_vcf
+
+def run(script: str, *args: str) -> subprocess.CompletedProcess:
+    return subprocess.run([sys.executable, script, *args], capture_output=True, text=True, check=True)
+
+def vcf_records(path: str) -> list:
+    # (INFO, FORMAT values) dicts of every record, read as text so that the test does not rely on cyvcf2
+    records = []
+    with open(path) as f:
+        for line in f:
+            if line.startswith("#"):
+                continue
+            fields = line.rstrip("\n").split("\t")
+            info = dict(kv.split("=") for kv in fields[7].split(";"))
+            records.append((info, dict(zip(fields[8].split(":"), fields[9].split(":")))))
+    return records
+
+def parse_args(*args: str):
+    # command line arguments of csv_from_vcf.py, with an input and output so that parse_cla accepts them
+    with mock.patch.object(sys, "argv", ["csv_from_vcf.py", "input.vcf", "-o", "output", *args]):
+        return csv_from_vcf.parse_cla()
+
+class FakeVariant:
+    # The attributes of a cyvcf2 Variant of a single-sample record that the helpers use. `fmt` maps FORMAT
+    # fields to their value in the sample, as cyvcf2 returns them: [[1]] for integers and ["1|2"] for strings
+    def __init__(self, chrom="chr1", pos=100, ref="A", alt=(), info=None, fmt=None, gt=(0, 0, False)):
+        self.CHROM = chrom
+        self.POS = pos
+        self.REF = ref
+        self.ALT = list(alt)
+        self.INFO = info or dict()
+        self.fmt = fmt or dict()
+        self.genotypes = [list(gt)]
+        self.end = pos + len(ref) - 1
+
+    def format(self, tag: str):
+        # cyvcf2 returns None for FORMAT fields that are not in the record
+        return self.fmt.get(tag)
+
+class FakeVCF:
+    # The region queries of a cyvcf2 VCF object with an index, returning the records that overlap the region
+    def __init__(self, variants: list, seqnames: list):
+        self.variants = variants
+        self.seqnames = seqnames
+        self.queries = []
+
+    def __call__(self, region: str) -> list:
+        self.queries.append(region)
+        chrom, start, end = csv_from_vcf.parse_region(region)
+        end = end if end is not None else float("inf")
+        return [
+            v for v in self.variants
+            if v.CHROM == chrom and v.POS <= end and csv_from_vcf.record_end(v) >= start
+        ]
+
+def parse_record(caller: str, variant) -> tuple:
+    # (values per column, warnings) that the FORMAT parser of `caller` adds for the record
+    warnings = []
+    df = csv_from_vcf.new_columns("keep", "ignore", False, [])
+    df = csv_from_vcf.FORMAT_PARSERS[caller](df, variant, warnings, 1)
+    return {col: values[0] for col, values in df.items() if values}, warnings
+
+def fixture_df(name: str, caller: str, **kwargs):
+    return csv_from_vcf.df_from_vcf(csv_from_vcf.InputFiles(), os.path.join(DATA_DIR, name), caller=caller, **kwargs)
+
+@unittest.skipUnless(HAS_DEPENDENCIES, "requires cyvcf2, numpy, and pandas")
+class TestRoundTrip(unittest.TestCase):
+    @classmethod
+    def setUpClass(cls):
+        cls.tmp = tempfile.TemporaryDirectory()
+        cls.vcf_dir = os.path.join(cls.tmp.name, "vcfs")
+        run(
+            SIMULATE, "-o", cls.vcf_dir, "--samples", str(N_SAMPLES), "--loci", str(N_LOCI),
+            "--skip-rate", "0.2", "--seed", "1",
+        )
+        cls.vcf_files = sorted(os.listdir(cls.vcf_dir))
+        cls.records = vcf_records(os.path.join(cls.vcf_dir, cls.vcf_files[0]))
+
+    @classmethod
+    def tearDownClass(cls):
+        cls.tmp.cleanup()
+
+    def convert(self, name: str, *args: str) -> str:
+        output = os.path.join(self.tmp.name, name)
+        run(CSV_FROM_VCF, self.vcf_dir, "-o", output, *args)
+        return output
+
+    def read_first_csv(self, output: str):
+        import pandas as pd
+        return pd.read_csv(os.path.join(output, self.vcf_files[0].replace(".vcf", ".csv")))
+
+    def test_csv(self):
+        import pandas as pd
+        output = self.convert("csv")
+        for vcf_file in self.vcf_files:
+            df = pd.read_csv(os.path.join(output, vcf_file.replace(".vcf", ".csv")))
+            self.assertEqual(len(df), N_LOCI)
+            self.assertEqual(list(df.columns[:5]), ["str_id", "copy_number", "frequencies", "genotype", "depth"])
+            self.assertTrue(df["str_id"].is_unique)
+        index = pd.read_csv(os.path.join(output, "index.tsv"), sep="\t")
+        self.assertEqual(sorted(index["sample"]), [f.removesuffix(".vcf") for f in self.vcf_files])
+        self.assertTrue((index["records_written"] == N_LOCI).all())
+
+    def test_jsonl(self):
+        output = self.convert("jsonl", "--format", "jsonl")
+        with open(os.path.join(output, self.vcf_files[0].replace(".vcf", ".jsonl"))) as f:
+            rows = [json.loads(line) for line in f]
+        self.assertEqual(len(rows), N_LOCI)
+        self.assertTrue(all(isinstance(row["frequencies"], (dict, type(None))) for row in rows))
+
+    def test_sqlite(self):
+        output = self.convert("cohort.sqlite", "--format", "sqlite")
+        with sqlite3.connect(output) as conn:
+            counts = dict(conn.execute("SELECT sample, COUNT(*) FROM genotypes GROUP BY sample").fetchall())
+        self.assertEqual(counts, {f.removesuffix(".vcf"): N_LOCI for f in self.vcf_files})
+
+    @unittest.skipUnless(importlib.util.find_spec("pyarrow") is not None, "requires pyarrow")
+    def test_parquet(self):
+        import pandas as pd
+        output = self.convert("parquet", "--format", "parquet")
+        df = pd.read_parquet(os.path.join(output, self.vcf_files[0].replace(".vcf", ".parquet")))
+        self.assertEqual(len(df), N_LOCI)
+
+    def test_skip_tags(self):
+        df = self.read_first_csv(self.convert("skip_tags", "--skip-tags", "DPZERO,DPOOR,CNZERO,CNOOR,CNMISSING,AMBGT"))
+        self.assertEqual(len(df), sum(fmt["FT"] == "PASS" for _, fmt in self.records))
+        self.assertTrue(df["genotype"].notna().all())
+
+    def test_min_depth(self):
+        df = self.read_first_csv(self.convert("min_depth", "--min-depth", "30"))
+        self.assertEqual(len(df), sum(int(fmt["DP"]) >= 30 for _, fmt in self.records))
+        self.assertTrue((df["depth"] >= 30).all())
+
+    def test_period(self):
+        df = self.read_first_csv(self.convert("period", "--period", "3,4"))
+        self.assertEqual(len(df), sum(info["PERIOD"] in ("3", "4") for info, _ in self.records))
+
+    def test_info_filter(self):
+        df = self.read_first_csv(self.convert("info_filter", "--info-filter", "RU=AAT"))
+        self.assertEqual(len(df), sum(info["RU"] == "AAT" for info, _ in self.records))
+
+    def test_limit(self):
+        df = self.read_first_csv(self.convert("limit", "--limit", "10"))
+        self.assertEqual(len(df), 10)
+
+@unittest.skipUnless(HAS_PANDAS, "requires numpy and pandas")
+class TestCsvOutput(unittest.TestCase):
+    def write_csv(self, *args: str) -> list:
+        # lines of a small table written with the CSV options of the arguments, line terminators included
+        import numpy as np
+        import pandas as pd
+        df = pd.DataFrame({
+            "str_id": ["chr1_99", "chr1,2"],
+            "depth": pd.array([30, None], dtype="Int64"),
+            "depth_norm": [15.25, np.nan],
+        })
+        with tempfile.TemporaryDirectory() as tmp:
+            path = os.path.join(tmp, "output.csv")
+            csv_from_vcf.write_df(df, path, "csv", csv_from_vcf.csv_options(parse_args(*args)))
+            with open(path, newline="") as f:
+                return f.read().splitlines(keepends=True)
+
+    def test_default_dialect(self):
+        self.assertEqual(self.write_csv(), ["str_id,depth,depth_norm\n", "chr1_99,30,15.25\n", '"chr1,2",,\n'])
+
+    def test_dialect(self):
+        lines = self.write_csv("--delimiter", "tab", "--quoting", "all", "--line-terminator", "CRLF")
+        self.assertEqual(lines[0], '"str_id"\t"depth"\t"depth_norm"\r\n')
+        self.assertEqual(lines[1], '"chr1_99"\t"30"\t"15.25"\r\n')
+
+    def test_quoting_none(self):
+        # delimiters inside fields are escaped instead of quoted
+        self.assertEqual(self.write_csv("--quoting", "none")[2], "chr1\\,2,,\n")
+
+    def test_na_string(self):
+        # missing integers and decimal numbers alike
+        self.assertEqual(self.write_csv("--na-string", "NA")[2], '"chr1,2",NA,NA\n')
+
+    def test_decimal_comma(self):
+        # columns are separated by semicolons, so that decimal commas need no quoting
+        self.assertEqual(self.write_csv("--decimal-comma")[:2], ["str_id;depth;depth_norm\n", "chr1_99;30;15,25\n"])
+
+@unittest.skipUnless(HAS_PANDAS, "requires numpy and pandas")
+class TestFloatFormatter(unittest.TestCase):
+    def test_fixed_point(self):
+        formatter = csv_from_vcf.float_formatter(None, ".", "")
+        self.assertEqual(formatter(1e-7), "0.0000001")
+        self.assertEqual(formatter(15.0), "15.0")
+        self.assertEqual(formatter(float("nan")), "")
+
+    def test_precision(self):
+        formatter = csv_from_vcf.float_formatter(2, ",", "NA")
+        self.assertEqual(formatter(1e-7), "0,00")
+        self.assertEqual(formatter(15.256), "15,26")
+        self.assertEqual(formatter(float("nan")), "NA")
+
+    def test_negative_precision(self):
+        with self.assertRaises(SystemExit), mock.patch.object(sys, "stderr", io.StringIO()):
+            parse_args("--float-precision", "-1")
+
+@unittest.skipUnless(HAS_PANDAS, "requires numpy and pandas")
+class TestColumnOrder(unittest.TestCase):
+    def test_ordered_columns(self):
+        columns = ["str_id", "copy_number", "frequencies", "genotype", "depth"]
+        self.assertEqual(
+            csv_from_vcf.ordered_columns(columns, ["depth", "str_id"]),
+            ["depth", "str_id", "copy_number", "frequencies", "genotype"],
+        )
+        with self.assertRaises(ValueError):
+            csv_from_vcf.ordered_columns(columns, ["classification"])
+
+    def test_parse_column_order(self):
+        self.assertEqual(parse_args("--column-order", "depth, str_id").column_order, ["depth", "str_id"])
+        self.assertEqual(parse_args("--layout", "long", "--column-order", "sample").column_order, ["sample"])
+        # unknown columns, columns listed twice, and columns that are not written with the arguments
+        for column_order in ("unknown", "depth,depth", "classification", "allele_length"):
+            with self.assertRaises(SystemExit), mock.patch.object(sys, "stderr", io.StringIO()):
+                parse_args("--column-order", column_order)
+
+@unittest.skipUnless(HAS_PANDAS, "requires numpy and pandas")
+class TestMatrixBuilder(unittest.TestCase):
+    def write_matrix(self, max_memory: int = None) -> str:
+        import pandas as pd
+        loci = [f"chr1_{i}" for i in range(5)]
+        matrix = csv_from_vcf.MatrixBuilder(max_memory)
+        matrix.add("a", pd.Series([10, 11, 12], index=loci[:3]))
+        matrix.add("b", pd.Series([20, 22], index=[loci[0], loci[2]]))
+        matrix.add("a", pd.Series([13, 14], index=loci[3:]))
+        matrix.add("b", pd.Series([21, 23, 24], index=[loci[1], loci[3], loci[4]]))
+        self.assertEqual(matrix.spill_dir is not None, max_memory is not None)
+        with tempfile.TemporaryDirectory() as tmp:
+            path = os.path.join(tmp, "matrix.csv")
+            matrix.write(path, csv_from_vcf.csv_options(parse_args()))
+            with open(path) as f:
+                return f.read()
+
+    def test_spill(self):
+        # a budget of 1 byte spills every chunk, to buckets of 2 loci
+        with mock.patch.object(csv_from_vcf, "MATRIX_BUCKET_LOCI", 2):
+            spilled = self.write_matrix(max_memory=1)
+        self.assertEqual(spilled, self.write_matrix())
+        self.assertEqual(
+            spilled.splitlines(),
+            ["str_id,a,b", "chr1_0,10,20", "chr1_1,11,21", "chr1_2,12,22", "chr1_3,13,23", "chr1_4,14,24"],
+        )
+
+@unittest.skipUnless(HAS_PANDAS, "requires numpy and pandas")
+class TestDeduplicateSamples(unittest.TestCase):
+    SAMPLES = {"a/s1.vcf": ["s1"], "b/s1.vcf": ["s1"], "b/s2.vcf": ["s2"]}
+
+    def deduplicate(self, policy: str, vcf_files: list = None, **kwargs) -> tuple:
+        # (files, kept VCF files, warnings)
+        files = csv_from_vcf.InputFiles()
+        files.samples = lambda vcf_file: self.SAMPLES[vcf_file]
+        with mock.patch.object(sys, "stderr", io.StringIO()) as stderr:
+            keep = csv_from_vcf.deduplicate_samples(files, vcf_files or list(self.SAMPLES), policy, **kwargs)
+        return files, keep, stderr.getvalue()
+
+    def test_warn(self):
+        _, keep, warnings = self.deduplicate("warn")
+        self.assertEqual(keep, list(self.SAMPLES))
+        self.assertIn("sample 's1' in b/s1.vcf was already found in a/s1.vcf", warnings)
+
+    def test_error(self):
+        with self.assertRaises(RuntimeError):
+            self.deduplicate("error")
+
+    def test_keep_first(self):
+        _, keep, warnings = self.deduplicate("keep-first")
+        self.assertEqual(keep, ["a/s1.vcf", "b/s2.vcf"])
+        self.assertIn("skipping file", warnings)
+
+    def test_suffix(self):
+        files, keep, _ = self.deduplicate("suffix")
+        self.assertEqual(keep, list(self.SAMPLES))
+        self.assertEqual(files.sample_suffixes, {("b/s1.vcf", "s1"): 2})
+
+    def test_seen(self):
+        # with --watch, a file that was converted in an earlier check is not a duplicate of itself
+        seen = {"s1": ["a/s1.vcf"]}
+        _, keep, warnings = self.deduplicate("error", ["a/s1.vcf", "b/s2.vcf"], seen=seen)
+        self.assertEqual((keep, warnings), (["a/s1.vcf", "b/s2.vcf"], ""))
+        with self.assertRaises(RuntimeError):
+            self.deduplicate("error", ["b/s1.vcf"], seen=seen)
+
+    def test_lenient(self):
+        files = csv_from_vcf.InputFiles()
+        files.samples = mock.Mock(side_effect=OSError("unreadable"))
+        with mock.patch.object(sys, "stderr", io.StringIO()):
+            self.assertEqual(csv_from_vcf.deduplicate_samples(files, ["a/s1.vcf"], lenient=True), [])
+            with self.assertRaises(OSError):
+                csv_from_vcf.deduplicate_samples(files, ["a/s1.vcf"])
+
+@unittest.skipUnless(HAS_PANDAS, "requires numpy and pandas")
+class TestCheckpoint(unittest.TestCase):
+    def setUp(self):
+        self.tmp = tempfile.TemporaryDirectory()
+        self.addCleanup(self.tmp.cleanup)
+        self.files = csv_from_vcf.InputFiles()
+        self.vcf_file = self.write("sample.vcf", "##fileformat=VCFv4.2\n")
+        self.part = self.write("sample.chr2.vcf", "##fileformat=VCFv4.2\n")
+        self.output = self.write("sample.csv", "str_id\n")
+
+    def write(self, name: str, content: str) -> str:
+        path = os.path.join(self.tmp.name, name)
+        with open(path, "w") as f:
+            f.write(content)
+        return path
+
+    def checkpoint(self, sample: str = None, parts: list = None) -> dict:
+        # checkpoint as main writes it after converting the VCF file
+        key = csv_from_vcf.checkpoint_key(self.vcf_file, sample)
+        sha256 = csv_from_vcf.files_sha256(self.files, [self.vcf_file] + (parts or []))
+        return {"completed": {key: {"sha256": sha256, "report": {"output": self.output}}}}
+
+    def test_round_trip(self):
+        path = os.path.join(self.tmp.name, "checkpoint.json")
+        self.assertEqual(csv_from_vcf.read_checkpoint(path), {"completed": dict()})
+        csv_from_vcf.write_checkpoint(path, self.checkpoint())
+        self.assertEqual(csv_from_vcf.read_checkpoint(path), self.checkpoint())
+        self.assertFalse(os.path.exists(f"{path}.tmp"))
+
+    def test_is_completed(self):
+        checkpoint = self.checkpoint()
+        self.assertTrue(csv_from_vcf.is_completed(self.files, checkpoint, self.vcf_file))
+        # per sample of multi-sample VCF files
+        self.assertFalse(csv_from_vcf.is_completed(self.files, checkpoint, self.vcf_file, "s1"))
+        self.assertTrue(csv_from_vcf.is_completed(self.files, self.checkpoint("s1"), self.vcf_file, "s1"))
+
+    def test_modified_input(self):
+        checkpoint = self.checkpoint()
+        self.write("sample.vcf", "##fileformat=VCFv4.3\n")
+        self.assertFalse(csv_from_vcf.is_completed(self.files, checkpoint, self.vcf_file))
+
+    def test_modified_part(self):
+        checkpoint = self.checkpoint(parts=[self.part])
+        self.assertTrue(csv_from_vcf.is_completed(self.files, checkpoint, self.vcf_file, parts=[self.part]))
+        self.write("sample.chr2.vcf", "##fileformat=VCFv4.3\n")
+        self.assertFalse(csv_from_vcf.is_completed(self.files, checkpoint, self.vcf_file, parts=[self.part]))
+
+    def test_missing_output(self):
+        checkpoint = self.checkpoint()
+        os.remove(self.output)
+        self.assertFalse(csv_from_vcf.is_completed(self.files, checkpoint, self.vcf_file))
+
+@unittest.skipUnless(HAS_PANDAS, "requires numpy and pandas")
+class TestLongLayout(unittest.TestCase):
+    def test_long_layout(self):
+        import numpy as np
+        import pandas as pd
+        df = pd.DataFrame({
+            "str_id": ["chr1_99", "chr1_199", "chr1_299", "chr1_399"],
+            "genotype": [[10, 12], [7, 7], [8], np.nan],
+            "frequencies": [{10: 5, 12: 3}, {7: 8}, np.nan, {4: 1}],
+            "depth": [8, 8, 2, 1],
+            "chrom": ["chr1"] * 4,
+        })
+        long = csv_from_vcf.long_layout(df, "s1")
+        # loci without a genotype have no alleles, coordinates are kept for partitioning
+        self.assertEqual(list(long.columns), csv_from_vcf.LONG_COLUMNS + ["chrom"])
+        self.assertEqual(long["str_id"].tolist(), ["chr1_99", "chr1_99", "chr1_199", "chr1_199", "chr1_299"])
+        self.assertTrue((long["sample"] == "s1").all())
+        self.assertEqual(long["allele_length"].tolist(), [10, 12, 7, 7, 8])
+        self.assertEqual(long["allele_index"].tolist(), [0, 1, 0, 1, 0])
+        # homozygous alleles both get the reads of their length, missing frequencies give missing support
+        self.assertEqual(long["support"].iloc[:4].tolist(), [5, 3, 8, 8])
+        self.assertTrue(pd.isna(long["support"].iloc[4]))
+
+@unittest.skipUnless(HAS_PANDAS, "requires numpy and pandas")
+class TestRegions(unittest.TestCase):
+    def test_region_list(self):
+        self.assertEqual(
+            csv_from_vcf.region_list("chr1, chr2:100,chrX:100-200"), ["chr1", "chr2:100", "chrX:100-200"]
+        )
+        for s in ("", "chr1:a-b", "chr1:100-", "chr1 :100"):
+            with self.assertRaises(argparse.ArgumentTypeError):
+                csv_from_vcf.region_list(s)
+
+    def test_parse_region(self):
+        self.assertEqual(csv_from_vcf.parse_region("chr1"), ("chr1", 1, None))
+        self.assertEqual(csv_from_vcf.parse_region("chr1:100"), ("chr1", 100, None))
+        self.assertEqual(csv_from_vcf.parse_region("chr1:100-200"), ("chr1", 100, 200))
+
+    def test_start_after_end(self):
+        self.assertEqual(csv_from_vcf.region_list("chr1:100-100"), ["chr1:100-100"])
+        with self.assertRaises(argparse.ArgumentTypeError):
+            csv_from_vcf.region_list("chr1:200-100")
+
+@unittest.skipUnless(HAS_PANDAS, "requires numpy and pandas")
+class TestIntervalIndex(unittest.TestCase):
+    def test_interval_index(self):
+        # overlapping and adjacent regions are merged, regions without an end extend to the end of the contig
+        index = csv_from_vcf.interval_index(["chr1:150-300", "chr1:100-200", "chr1:301-400", "chr1:500", "chr2"])
+        self.assertEqual(index, {"chr1": ([100, 500], [400, float("inf")]), "chr2": ([1], [float("inf")])})
+
+    def test_in_index(self):
+        index = csv_from_vcf.interval_index(["chr1:100-200", "chr1:500"])
+        self.assertTrue(csv_from_vcf.in_index(FakeVariant(pos=150), index))
+        self.assertTrue(csv_from_vcf.in_index(FakeVariant(pos=10_000), index))
+        self.assertFalse(csv_from_vcf.in_index(FakeVariant(pos=300), index))
+        self.assertFalse(csv_from_vcf.in_index(FakeVariant(chrom="chr2", pos=150), index))
+        # records overlap regions with their whole length, up to END if it is set
+        self.assertFalse(csv_from_vcf.in_index(FakeVariant(pos=98, ref="AA"), index))
+        self.assertTrue(csv_from_vcf.in_index(FakeVariant(pos=99, ref="AA"), index))
+        self.assertTrue(csv_from_vcf.in_index(FakeVariant(pos=90, info={"END": 120}), index))
+
+@unittest.skipUnless(HAS_PANDAS, "requires numpy and pandas")
+class TestIndexedRecords(unittest.TestCase):
+    def test_indexed_records(self):
+        variants = [
+            FakeVariant(pos=150),
+            # overlaps both chr1 regions
+            FakeVariant(pos=250, ref="A" * 60),
+            FakeVariant(pos=350),
+            FakeVariant(chrom="chr2", pos=50),
+        ]
+        vcf = FakeVCF(variants, ["chr1", "chr2"])
+        regions = ["chr2:1-100", "chr1:100-200", "chr1:150-250", "chr1:300-400", "chr3"]
+        records = list(csv_from_vcf.indexed_records(vcf, regions))
+        # regions are merged and queried in the contig order of the header, each record is only yielded once
+        self.assertEqual(vcf.queries, ["chr1:100-250", "chr1:300-400", "chr2:1-100", "chr3:1"])
+        self.assertEqual(records, [(".", variant) for variant in variants])
+
+    def test_bed_regions(self):
+        with tempfile.TemporaryDirectory() as tmp:
+            path = os.path.join(tmp, "regions.bed")
+            with open(path, "w") as f:
+                f.write("track name=regions\nchr1\t99\t200\nchr1\t150\t250\tname\nchr2\t0\t100\n")
+            self.assertEqual(csv_from_vcf.bed_regions(path), ["chr1:100-250", "chr2:1-100"])
+            with open(path, "w") as f:
+                f.write("chr1\tstart\tend\n")
+            with self.assertRaises(argparse.ArgumentTypeError):
+                csv_from_vcf.bed_regions(path)
+
+@unittest.skipUnless(HAS_PANDAS, "requires numpy and pandas")
+class TestChecksums(unittest.TestCase):
+    def setUp(self):
+        self.tmp = tempfile.TemporaryDirectory()
+        self.addCleanup(self.tmp.cleanup)
+        self.files = csv_from_vcf.InputFiles()
+        self.vcf_file = os.path.join(self.tmp.name, "vcfs", "sample.vcf")
+        os.makedirs(os.path.dirname(self.vcf_file))
+        with open(self.vcf_file, "wb") as f:
+            f.write(b"##fileformat=VCFv4.2\n")
+        with open(self.vcf_file, "rb") as f:
+            content = f.read()
+        self.md5 = hashlib.md5(content).hexdigest()
+        self.sha256 = hashlib.sha256(content).hexdigest()
+
+    def manifest(self, content: str) -> str:
+        path = os.path.join(self.tmp.name, "checksums.txt")
+        with open(path, "w") as f:
+            f.write(content)
+        return path
+
+    def test_read_checksums(self):
+        # md5sum and sha256sum output, with paths relative to the manifest, in text or binary (*) mode
+        manifest = self.manifest(f"# comment\n{self.md5.upper()}  vcfs/sample.vcf\n{self.sha256} *other.vcf\n")
+        self.assertEqual(csv_from_vcf.read_checksums(manifest), {
+            self.vcf_file: ("md5", self.md5),
+            os.path.join(self.tmp.name, "other.vcf"): ("sha256", self.sha256),
+        })
+        for line in ("xyz  vcfs/sample.vcf\n", "abc123  vcfs/sample.vcf\n", f"{self.md5}\n"):
+            with self.assertRaises(ValueError):
+                csv_from_vcf.read_checksums(self.manifest(line))
+
+    def test_verify_checksum(self):
+        checksums = {self.vcf_file: ("sha256", self.sha256)}
+        self.assertIsNone(csv_from_vcf.verify_checksum(self.files, self.vcf_file, checksums))
+        msg = csv_from_vcf.verify_checksum(self.files, self.vcf_file, {self.vcf_file: ("md5", "0")})
+        self.assertEqual(msg, f"md5 checksum of {self.vcf_file} is {self.md5}, expected 0")
+        self.assertIn("is not listed", csv_from_vcf.verify_checksum(self.files, self.vcf_file, dict()))
+
+    def test_checksum_ok(self):
+        checksums = {self.vcf_file: ("md5", "0")}
+        with mock.patch.object(sys, "stderr", io.StringIO()):
+            self.assertTrue(csv_from_vcf.checksum_ok(self.files, self.vcf_file, checksums, "warn"))
+            self.assertFalse(csv_from_vcf.checksum_ok(self.files, self.vcf_file, checksums, "skip"))
+            with self.assertRaises(SystemExit):
+                csv_from_vcf.checksum_ok(self.files, self.vcf_file, checksums, "error")
+            # remote files are not downloaded to verify them
+            self.assertTrue(csv_from_vcf.checksum_ok(self.files, "https://example.org/sample.vcf", checksums, "error"))
+
+@unittest.skipUnless(HAS_PANDAS, "requires numpy and pandas")
+class TestArchiveMembers(unittest.TestCase):
+    NAMES = ["ok/sample.vcf", "../evil.vcf", "/absolute.vcf", "ok/../../evil.vcf", "ok/notes.txt"]
+
+    def setUp(self):
+        self.tmp = tempfile.TemporaryDirectory()
+        self.addCleanup(self.tmp.cleanup)
+        self.files = csv_from_vcf.InputFiles()
+        self.extensions = csv_from_vcf.parse_extensions(csv_from_vcf.DEFAULT_EXTENSIONS)
+        # archives are kept open by InputFiles
+        self.addCleanup(lambda: [arc.close() for _, arc in self.files.archives.values()])
+
+    def members(self, archive: str) -> tuple:
+        # (member paths, warnings)
+        with mock.patch.object(sys, "stderr", io.StringIO()) as stderr:
+            members = self.files.archive_members(archive, self.extensions)
+        return members, stderr.getvalue()
+
+    def test_tar(self):
+        archive = os.path.join(self.tmp.name, "vcfs.tar")
+        with tarfile.open(archive, "w") as tar:
+            for name in self.NAMES:
+                info = tarfile.TarInfo(name)
+                info.size = len(name)
+                tar.addfile(info, io.BytesIO(name.encode()))
+        members, warnings = self.members(archive)
+        # member names that point outside of --output are skipped
+        self.assertEqual(members, [os.path.join(archive, "ok/sample.vcf")])
+        self.assertEqual(warnings.count("unsafe member name"), 3)
+        self.assertEqual(self.files.size(members[0]), len("ok/sample.vcf"))
+        with self.files.open(members[0]) as f:
+            self.assertEqual(f.read(), b"ok/sample.vcf")
+
+    def test_zip(self):
+        archive = os.path.join(self.tmp.name, "vcfs.zip")
+        with zipfile.ZipFile(archive, "w") as z:
+            for name in self.NAMES + ["ok\\..\\..\\evil.vcf"]:
+                z.writestr(name, name)
+        members, warnings = self.members(archive)
+        self.assertEqual(members, [os.path.join(archive, "ok/sample.vcf")])
+        self.assertEqual(warnings.count("unsafe member name"), 4)
+
+@unittest.skipUnless(HAS_PANDAS, "requires numpy and pandas")
+class TestGangstr(unittest.TestCase):
+    def test_parse(self):
+        variant = FakeVariant(fmt={"DP": [[20]], "REPCN": [[4, 3]], "ENCLREADS": ["3,8|4,10"]}, gt=(0, 1, False))
+        row, warnings = parse_record("gangstr", variant)
+        self.assertEqual(row, {"copy_number": 2, "depth": 20, "frequencies": {3: 8, 4: 10}, "genotype": [3, 4]})
+        self.assertEqual(warnings, [])
+
+    def test_missing_fields(self):
+        row, warnings = parse_record("gangstr", FakeVariant(fmt={"DP": [[5]], "ENCLREADS": ["NULL"]}))
+        self.assertEqual(row["depth"], 5)
+        self.assertTrue(math.isnan(row["frequencies"]) and math.isnan(row["genotype"]))
+        self.assertEqual(len(warnings), 2)
+
+    @unittest.skipUnless(HAS_DEPENDENCIES, "requires cyvcf2")
+    def test_fixture(self):
+        df = fixture_df("gangstr.vcf", "gangstr")
+        self.assertEqual(df["str_id"].tolist(), ["chr1_99", "chr1_199"])
+        self.assertEqual(df["copy_number"].tolist(), [2, 2])
+        self.assertEqual(df["depth"].tolist(), [20, 5])
+        self.assertEqual(df["genotype"].tolist(), [[3, 4], [3, 3]])
+        self.assertEqual(df["frequencies"][0], {3: 8, 4: 10})
+        # ENCLREADS is NULL without enclosing reads
+        self.assertTrue(df["frequencies"].isna()[1])
+
+@unittest.skipUnless(HAS_PANDAS, "requires numpy and pandas")
+class TestHipstr(unittest.TestCase):
+    def variant(self, fmt: dict, gt=(1, 0, True)) -> FakeVariant:
+        # 4 CAG repeats with a flanking base on each side
+        info = {"START": 101, "END": 112, "PERIOD": 3}
+        return FakeVariant(ref="ACAGCAGCAGCAGT", alt=["ACAGCAGCAGCAGCAGT"], info=info, fmt=fmt, gt=gt)
+
+    def test_hipstr_units(self):
+        variant = self.variant(dict())
+        self.assertEqual([csv_from_vcf.hipstr_units(variant, diff) for diff in (-3, 0, 3)], [3, 4, 5])
+        # without START and END, the whole reference allele is the repeat
+        variant = FakeVariant(ref="CAGCAG", info={"PERIOD": 3})
+        self.assertEqual(csv_from_vcf.hipstr_units(variant, 3), 3)
+
+    def test_parse(self):
+        variant = self.variant({"GB": ["3|0"], "DP": [[13]], "ALLREADS": ["-3|1;0|7;3|5;3|1"]})
+        row, warnings = parse_record("hipstr", variant)
+        self.assertEqual(row, {"copy_number": 2, "depth": 13, "frequencies": {3: 1, 4: 7, 5: 6}, "genotype": [4, 5]})
+        self.assertEqual(warnings, [])
+        # GB is in haplotype order
+        self.assertEqual(csv_from_vcf.phased_genotype(variant, "hipstr"), [5, 4])
+
+    def test_missing_fields(self):
+        row, warnings = parse_record("hipstr", self.variant({"GB": ["0/3"], "DP": [[4]], "ALLREADS": ["."]}))
+        self.assertEqual(row["genotype"], [4, 5])
+        self.assertTrue(math.isnan(row["frequencies"]))
+        self.assertEqual(len(warnings), 1)
+
+    @unittest.skipUnless(HAS_DEPENDENCIES, "requires cyvcf2")
+    def test_fixture(self):
+        df = fixture_df("hipstr.vcf", "hipstr", keep_phase=True)
+        self.assertEqual(df["copy_number"].tolist(), [2, 2])
+        self.assertEqual(df["depth"].tolist(), [13, 4])
+        self.assertEqual(df["genotype"].tolist(), [[5, 4], [3, 4]])
+        self.assertEqual(df["phased"].tolist(), [True, False])
+        self.assertEqual(df["frequencies"][0], {3: 1, 4: 7, 5: 5})
+        self.assertTrue(df["frequencies"].isna()[1])
+
+@unittest.skipUnless(HAS_PANDAS, "requires numpy and pandas")
+class TestExpansionhunter(unittest.TestCase):
+    def variant(self, **fmt) -> FakeVariant:
+        # ExpansionHunter writes all FORMAT fields as strings
+        fmt = {tag: [value] for tag, value in fmt.items()}
+        return FakeVariant(ref="C", alt=["<STR15>"], info={"REF": 21, "RU": "CAG"}, fmt=fmt)
+
+    def test_parse(self):
+        variant = self.variant(REPCN="15/20", SO="SPANNING/FLANKING", ADSP="10/8", ADFL="2/3", ADIR="0/1")
+        row, warnings = parse_record("expansionhunter", variant)
+        # only spanning reads count towards the frequencies
+        self.assertEqual(row, {"copy_number": 2, "depth": 24, "frequencies": {15: 10}, "genotype": [15, 20]})
+        self.assertEqual(warnings, [])
+
+    def test_homozygous_depth(self):
+        # the read counts of homozygous alleles are repeated for both alleles, but only counted once
+        variant = self.variant(REPCN="15/15", SO="SPANNING/SPANNING", ADSP="9/9", ADFL="4/4", ADIR="1/1")
+        row, _ = parse_record("expansionhunter", variant)
+        self.assertEqual(row, {"copy_number": 2, "depth": 14, "frequencies": {15: 9}, "genotype": [15, 15]})
+
+    def test_missing_fields(self):
+        row, warnings = parse_record("expansionhunter", self.variant(SO="SPANNING/SPANNING"))
+        self.assertTrue(all(math.isnan(row[col]) for col in ("copy_number", "depth", "frequencies", "genotype")))
+        self.assertEqual(len(warnings), 4)
+
+    @unittest.skipUnless(HAS_DEPENDENCIES, "requires cyvcf2")
+    def test_fixture(self):
+        df = fixture_df("expansionhunter.vcf", "expansionhunter")
+        self.assertEqual(df["str_id"].tolist(), ["chr4_3074875", "chr4_3075875", "chrX_147912049"])
+        self.assertEqual(df["copy_number"].tolist(), [2, 2, 1])
+        self.assertEqual(df["depth"].tolist(), [24, 14, 7])
+        self.assertEqual(df["genotype"].tolist(), [[15, 20], [15, 15], [20]])
+        self.assertEqual(df["frequencies"].tolist(), [{15: 10}, {15: 9}, {20: 6}])
+
+@unittest.skipUnless(HAS_PANDAS, "requires numpy and pandas")
+class TestFilterExpression(unittest.TestCase):
+    def evaluate(self, expression: str) -> bool:
+        # FORMAT values as written in the VCF record, CN is missing
+        variant = FakeVariant(info={"RU": "CAG", "PERIOD": 3})
+        values = {"DP": "30", "FT": "PASS", "CN": "."}
+        tree = csv_from_vcf.filter_expression(expression)
+        return bool(csv_from_vcf.evaluate_filter(tree, variant, values))
+
+    def test_operators(self):
+        self.assertTrue(self.evaluate("DP >= 20 && PERIOD == 3"))
+        self.assertTrue(self.evaluate("DP < 20 || RU == 'CAG'"))
+        self.assertTrue(self.evaluate("!(DP < 20)"))
+        self.assertFalse(self.evaluate("DP != 30"))
+        self.assertTrue(self.evaluate("10 < DP < 40 and DP > -1"))
+        self.assertTrue(self.evaluate('CHROM == "chr1" && POS > 99'))
+        self.assertFalse(self.evaluate("FT != 'PASS'"))
+
+    def test_string_literals(self):
+        # operators inside string literals are not translated
+        self.assertFalse(self.evaluate("RU == 'A&&B' || FT == '!'"))
+
+    def test_missing_and_mismatched_values(self):
+        # comparisons with missing fields, or of numbers with strings, are false
+        self.assertFalse(self.evaluate("CN > 1"))
+        self.assertTrue(self.evaluate("!(CN > 1)"))
+        self.assertFalse(self.evaluate("UNKNOWN == 1"))
+        self.assertFalse(self.evaluate("RU > 2"))
+
+    def test_invalid_expressions(self):
+        for expression in ("DP >=", "len(RU) > 2", "DP.real > 1", "__import__('os')", "DP + 1 > 2"):
+            with self.assertRaises(argparse.ArgumentTypeError):
+                csv_from_vcf.filter_expression(expression)
+
+@unittest.skipUnless(HAS_PANDAS, "requires numpy and pandas")
+class TestInfoFilter(unittest.TestCase):
+    def test_info_condition(self):
+        self.assertEqual(csv_from_vcf.info_condition("RU=CAG, CTG"), ("RU", "=", ["CAG", "CTG"]))
+        self.assertEqual(csv_from_vcf.info_condition("REF>=10"), ("REF", ">=", [10]))
+        self.assertEqual(csv_from_vcf.info_condition("PERIOD!=3"), ("PERIOD", "!=", [3]))
+        self.assertEqual(csv_from_vcf.info_condition("REF<2.5"), ("REF", "<", [2.5]))
+        for s in ("RU", "=CAG", "RU=", "REF>=1,2"):
+            with self.assertRaises(argparse.ArgumentTypeError):
+                csv_from_vcf.info_condition(s)
+
+    def test_info_matches(self):
+        variant = FakeVariant(info={"RU": "CAG", "REF": 12})
+        self.assertTrue(csv_from_vcf.info_matches(variant, ("RU", "=", ["CAG", "CTG"])))
+        self.assertFalse(csv_from_vcf.info_matches(variant, ("RU", "!=", ["CAG"])))
+        self.assertTrue(csv_from_vcf.info_matches(variant, ("REF", ">=", [10])))
+        self.assertFalse(csv_from_vcf.info_matches(variant, ("REF", "<", [10])))
+        # missing fields never match, and numbers are not compared with strings
+        self.assertFalse(csv_from_vcf.info_matches(variant, ("PERIOD", "!=", [3])))
+        self.assertFalse(csv_from_vcf.info_matches(variant, ("RU", ">", [3])))
+
+if __name__ == "__main__":
+    unittest.main()